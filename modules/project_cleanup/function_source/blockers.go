@@ -0,0 +1,86 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// projectBlockers returns why the project can't be deleted, nil if nothing is known to block
+// its deletion. Deleting such a project fails on every run until the blocker is removed by hand,
+// so it is reported as blocked instead of failed. If the project is a Shared VPC host project, it
+// also returns the service projects to detach before deleting it.
+func (c *cleaner) projectBlockers(ctx context.Context, projectId string) ([]string, *sharedVPCHost) {
+	blockers := c.bucketBlockers(ctx, projectId)
+	host := c.findSharedVPCHost(ctx, projectId)
+	if host != nil && len(host.kept) > 0 {
+		blockers = append(blockers, fmt.Sprintf("Shared VPC host of service projects which are kept [%s]", strings.Join(host.kept, ", ")))
+	}
+	return blockers, host
+}
+
+// bucketBlockers returns the buckets of the project whose locked retention policy blocks its
+// deletion.
+func (c *cleaner) bucketBlockers(ctx context.Context, projectId string) []string {
+	var blockers []string
+	var buckets []*storage.Bucket
+	err := c.buckets.ListBuckets(ctx, projectId, func(page *storage.Buckets) error {
+		buckets = append(buckets, page.Items...)
+		return nil
+	})
+	if !isServiceDisabled(err) {
+		c.listed(resourceBucket, projectId, len(buckets), err)
+	}
+	for _, bucket := range buckets {
+		if policy := bucket.RetentionPolicy; policy != nil && policy.IsLocked {
+			blockers = append(blockers, fmt.Sprintf("bucket [gs://%s] has a locked retention policy of %s", bucket.Name, retentionText(policy.RetentionPeriod)))
+		}
+	}
+	return blockers
+}
+
+// retentionText returns the retention period in seconds as days when it is a whole number of them.
+func retentionText(seconds int64) string {
+	day := int64(24 * time.Hour / time.Second)
+	if seconds > 0 && seconds%day == 0 {
+		return fmt.Sprintf("%d days", seconds/day)
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// isServiceDisabled reports whether the API isn't enabled in the project, the project then has
+// no resources of that API which could block its deletion.
+func isServiceDisabled(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "accessNotConfigured" {
+			return true
+		}
+	}
+	return strings.Contains(apiErr.Message, "SERVICE_DISABLED") || strings.Contains(apiErr.Message, "has not been used")
+}
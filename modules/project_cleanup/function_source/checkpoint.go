@@ -0,0 +1,326 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// Organization level steps of a run, recorded in the checkpoint once done.
+const (
+	stepOrganizationProjects = "organization_projects"
+	stepTagKeys              = "tag_keys"
+	stepSCCNotifications     = "scc_notifications"
+	stepCaiFeeds             = "cai_feeds"
+	stepBillingSinks         = "billing_sinks"
+)
+
+// checkpoint records the progress of a run stopped before its deadline, so that the next
+// run resumes where it stopped instead of starting again from the root folders.
+type checkpoint struct {
+	// Key identifies the targets and dry run setting the checkpoint applies to.
+	Key string `json:"key"`
+	// RunID and StartTime are the ones of the run which started the clean up.
+	RunID     string    `json:"run_id"`
+	StartTime time.Time `json:"start_time"`
+	// Folders maps every folder whose tree is done to whether it was deleted, or planned
+	// for deletion in dry run.
+	Folders map[string]bool `json:"folders"`
+	// Steps holds the organization level steps which are done.
+	Steps map[string]bool `json:"steps"`
+	mu    sync.Mutex
+}
+
+func newCheckpoint(key string, runID string, startTime time.Time) *checkpoint {
+	return &checkpoint{Key: key, RunID: runID, StartTime: startTime, Folders: map[string]bool{}, Steps: map[string]bool{}}
+}
+
+// checkpointKey identifies what a run cleans up, a checkpoint saved by a run cleaning up
+// other targets is ignored.
+func checkpointKey(config Config) string {
+	data, _ := json.Marshal(struct {
+		OrganizationId       string         `json:"organization_id"`
+		Targets              []TargetFolder `json:"targets"`
+		OrganizationProjects bool           `json:"organization_projects"`
+		DryRun               bool           `json:"dry_run"`
+	}{config.OrganizationId, config.targets(), config.CleanUpOrganizationProjects, config.DryRun})
+	return string(data)
+}
+
+// checkpointName returns the name of the checkpoint of the runs with the key, made of name
+// with a hash of the key before its extension. Every set of targets has its own checkpoint, so
+// that a run with other targets, e.g. a per run override, leaves it alone.
+func checkpointName(name string, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	extension := path.Ext(name)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(name, extension), hex.EncodeToString(sum[:8]), extension)
+}
+
+// folder returns whether the tree of the folder is done and whether the folder was deleted.
+func (cp *checkpoint) folder(name string) (deleted bool, done bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	deleted, done = cp.Folders[name]
+	return deleted, done
+}
+
+func (cp *checkpoint) folderDone(name string, deleted bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Folders[name] = deleted
+}
+
+func (cp *checkpoint) step(name string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.Steps[name]
+}
+
+func (cp *checkpoint) stepDone(name string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Steps[name] = true
+}
+
+func (cp *checkpoint) marshal() ([]byte, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return json.Marshal(cp)
+}
+
+// stateStore persists the checkpoint of every checkpoint key between runs.
+type stateStore interface {
+	// LoadCheckpoint returns the checkpoint saved for the key, nil if there is none.
+	LoadCheckpoint(ctx context.Context, key string) (*checkpoint, error)
+	SaveCheckpoint(ctx context.Context, key string, data []byte) error
+	// ClearCheckpoint removes the checkpoint saved for the key, if any.
+	ClearCheckpoint(ctx context.Context, key string) error
+}
+
+func unmarshalCheckpoint(data []byte) (*checkpoint, error) {
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	if cp.Folders == nil {
+		cp.Folders = map[string]bool{}
+	}
+	if cp.Steps == nil {
+		cp.Steps = map[string]bool{}
+	}
+	return cp, nil
+}
+
+// gcsStateStore keeps the checkpoint in a Cloud Storage object.
+type gcsStateStore struct {
+	service *storage.Service
+	bucket  string
+	object  string
+}
+
+func (s gcsStateStore) LoadCheckpoint(ctx context.Context, key string) (*checkpoint, error) {
+	object := checkpointName(s.object, key)
+	response, err := s.service.Objects.Get(s.bucket, object).Context(ctx).Download()
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint [gs://%s/%s], error [%s]", s.bucket, object, err.Error())
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err == nil {
+		var cp *checkpoint
+		if cp, err = unmarshalCheckpoint(data); err == nil {
+			return cp, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to read checkpoint [gs://%s/%s], error [%s]", s.bucket, object, err.Error())
+}
+
+func (s gcsStateStore) SaveCheckpoint(ctx context.Context, key string, data []byte) error {
+	object := &storage.Object{Name: checkpointName(s.object, key), ContentType: "application/json"}
+	_, err := s.service.Objects.Insert(s.bucket, object).Media(bytes.NewReader(data)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to write checkpoint [gs://%s/%s], error [%s]", s.bucket, object.Name, err.Error())
+	}
+	return nil
+}
+
+func (s gcsStateStore) ClearCheckpoint(ctx context.Context, key string) error {
+	object := checkpointName(s.object, key)
+	err := s.service.Objects.Delete(s.bucket, object).Context(ctx).Do()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete checkpoint [gs://%s/%s], error [%s]", s.bucket, object, err.Error())
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// fileStateStore keeps the checkpoints in local files next to path, it is used in tests.
+type fileStateStore struct {
+	path string
+}
+
+func (s fileStateStore) LoadCheckpoint(ctx context.Context, key string) (*checkpoint, error) {
+	name := checkpointName(s.path, key)
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint [%s], error [%s]", name, err.Error())
+	}
+	cp, err := unmarshalCheckpoint(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint [%s], error [%s]", name, err.Error())
+	}
+	return cp, nil
+}
+
+func (s fileStateStore) SaveCheckpoint(ctx context.Context, key string, data []byte) error {
+	name := checkpointName(s.path, key)
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write checkpoint [%s], error [%s]", name, err.Error())
+	}
+	return nil
+}
+
+func (s fileStateStore) ClearCheckpoint(ctx context.Context, key string) error {
+	name := checkpointName(s.path, key)
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete checkpoint [%s], error [%s]", name, err.Error())
+	}
+	return nil
+}
+
+// runDeadline returns when the run must stop starting new work: DeadlineMargin before the
+// earliest of the context deadline and RunTimeout after the start, zero if there is neither.
+func (c *cleaner) runDeadline(ctx context.Context) time.Time {
+	deadline, ok := ctx.Deadline()
+	if c.config.RunTimeout > 0 {
+		if timeout := c.clock().Add(c.config.RunTimeout); !ok || timeout.Before(deadline) {
+			deadline, ok = timeout, true
+		}
+	}
+	if !ok {
+		return time.Time{}
+	}
+	return deadline.Add(-c.config.DeadlineMargin)
+}
+
+// remaining returns the time left before the run deadline, false if the run has none.
+func (c *cleaner) remaining() (time.Duration, bool) {
+	if c.deadline.IsZero() {
+		return 0, false
+	}
+	return c.deadline.Sub(c.clock()), true
+}
+
+// stopped reports whether the run reached its deadline, in which case no new work is started.
+// The first call past the deadline logs it, every later one returns true.
+func (c *cleaner) stopped() bool {
+	if c.stop.Load() {
+		return true
+	}
+	if c.deadline.IsZero() || c.clock().Before(c.deadline) {
+		return false
+	}
+	if c.stop.CompareAndSwap(false, true) {
+		c.log.Printf("Stopping the run before its deadline [%s], the next run resumes from the checkpoint", c.deadline.Format(time.RFC3339))
+	}
+	return true
+}
+
+// loadCheckpoint resumes from the checkpoint of an earlier run of the same targets, or starts
+// a new one.
+func (c *cleaner) loadCheckpoint(ctx context.Context) {
+	key := checkpointKey(c.config)
+	c.checkpoint = newCheckpoint(key, c.report.RunID, c.report.StartTime)
+	if c.stateStore == nil {
+		return
+	}
+	cp, err := c.stateStore.LoadCheckpoint(ctx, key)
+	if err != nil {
+		c.errorf("%w", err)
+		return
+	}
+	if cp == nil {
+		return
+	}
+	if cp.Key != key {
+		c.log.Printf("Ignoring the checkpoint of run [%s], it has other targets or dry run setting", cp.RunID)
+		return
+	}
+	c.log.Printf("Resuming the clean up started by run [%s] at %s, %d folders done", cp.RunID, cp.StartTime.Format(time.RFC3339), len(cp.Folders))
+	c.checkpoint = cp
+	c.report.ResumedRunID = cp.RunID
+}
+
+// saveCheckpoint saves the checkpoint if the run stopped before its deadline, and clears it
+// once a run went through every target. The checkpoints of other targets are left alone.
+func (c *cleaner) saveCheckpoint(ctx context.Context) {
+	c.report.Stopped = c.stop.Load()
+	if c.stateStore == nil {
+		return
+	}
+	if !c.report.Stopped {
+		if err := c.stateStore.ClearCheckpoint(ctx, c.checkpoint.Key); err != nil {
+			c.errorf("%w", err)
+		}
+		return
+	}
+	data, err := c.checkpoint.marshal()
+	if err == nil {
+		err = c.stateStore.SaveCheckpoint(ctx, c.checkpoint.Key, data)
+	}
+	if err != nil {
+		c.errorf("%w", err)
+	}
+}
+
+// runStep runs the organization level step unless it is done according to the checkpoint or
+// the run is stopped.
+func (c *cleaner) runStep(ctx context.Context, name string, step func(ctx context.Context)) {
+	if c.checkpoint.step(name) || c.stopped() {
+		return
+	}
+	step(ctx)
+	if !c.stopped() {
+		c.checkpoint.stepDone(name)
+	}
+}
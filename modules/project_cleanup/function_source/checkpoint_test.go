@@ -0,0 +1,115 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// newCheckpointTestCleaner returns a cleaner keeping its checkpoint in path, whose clock passes
+// the deadline of the run once folders/300 is deleted.
+func newCheckpointTestCleaner(f *fakeCloud, path string) *cleaner {
+	config := testConfig()
+	config.RunTimeout = 10 * time.Minute
+	c := newTestCleaner(f, config)
+	c.stateStore = fileStateStore{path: path}
+	c.clock = func() time.Time {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.called("DeleteFolder", "folders/300") {
+			return testNow.Add(time.Hour)
+		}
+		return testNow
+	}
+	return c
+}
+
+func TestRunStopsBeforeItsDeadlineAndResumes(t *testing.T) {
+	f := newTestHierarchy()
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	first := newCheckpointTestCleaner(f, path).run(context.Background())
+
+	if !first.Stopped {
+		t.Fatalf("the run should stop once past its deadline")
+	}
+	if f.called("DeleteProject", "old-200") || f.called("DeleteFolder", "folders/200") {
+		t.Errorf("no work should be started past the deadline, got calls %v", f.calls)
+	}
+	key := checkpointKey(testConfig())
+	if !checkpointSaved(t, fileStateStore{path: path}, key) {
+		t.Fatalf("the checkpoint should be saved")
+	}
+
+	// folders/300 is done, a project showing up below it is left to the next full pass.
+	f.addProject("late-300", "300", oldTime, nil)
+	second := newTestCleaner(f, testConfig())
+	second.stateStore = fileStateStore{path: path}
+	report := second.run(context.Background())
+
+	if report.Stopped || report.ResumedRunID != first.RunID {
+		t.Errorf("got stopped %t and resumed run %q, want false and %q", report.Stopped, report.ResumedRunID, first.RunID)
+	}
+	if got := report.resource(resourceProject).Deleted; !sameStrings(got, []string{"old-200"}) {
+		t.Errorf("got deleted projects %v, want [old-200]", got)
+	}
+	if checkpointSaved(t, fileStateStore{path: path}, key) {
+		t.Errorf("the checkpoint should be cleared once the run went through every target")
+	}
+}
+
+func TestRunIgnoresTheCheckpointOfOtherTargets(t *testing.T) {
+	f := newTestHierarchy()
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	store := fileStateStore{path: path}
+	cp := newCheckpoint("other targets", "earlier-run", testNow)
+	cp.folderDone("folders/200", true)
+	data, err := cp.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveCheckpoint(context.Background(), "other targets", data); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCleaner(f, testConfig())
+	c.stateStore = store
+	report := c.run(context.Background())
+
+	if report.ResumedRunID != "" {
+		t.Errorf("got resumed run %q, the checkpoint of other targets should be ignored", report.ResumedRunID)
+	}
+	if !f.called("DeleteProject", "old-200") {
+		t.Errorf("projects below folders/200 should be cleaned up")
+	}
+	if !checkpointSaved(t, store, "other targets") {
+		t.Errorf("the checkpoint of other targets should be left alone")
+	}
+}
+
+// checkpointSaved reports whether the store holds a checkpoint for the key.
+func checkpointSaved(t *testing.T, store fileStateStore, key string) bool {
+	cp, err := store.LoadCheckpoint(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cp != nil
+}
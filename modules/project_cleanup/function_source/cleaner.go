@@ -0,0 +1,968 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/securitycenter/apiv1/securitycenterpb"
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	cloudresourcemanager2 "google.golang.org/api/cloudresourcemanager/v2"
+	cloudresourcemanager3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/servicemanagement/v1"
+)
+
+// cleaner walks the target folder hierarchy and removes the projects, folders and organization
+// level resources matching the configured settings.
+type cleaner struct {
+	clients
+	config                 Config
+	now                    time.Time
+	resourceCreationCutoff time.Time
+	log                    *structuredLogger
+	report                 *runReport
+	// projectSlots bounds the number of projects cleaned up concurrently.
+	projectSlots chan struct{}
+	// caller limits and retries every API call made through the clients.
+	caller *apiCaller
+	// sleep is used to wait for asynchronous deletions, replaced in tests.
+	sleep func(time.Duration)
+	// clock returns the current time to check the deadline, replaced in tests.
+	clock func() time.Time
+	// deadline is when the run stops starting new work, zero if it has none.
+	deadline time.Time
+	// stop is set once the run reached its deadline.
+	stop *atomic.Bool
+	// checkpoint records the progress of the run, see loadCheckpoint.
+	checkpoint *checkpoint
+	// protected maps the names of the resources which are never deleted to the reason.
+	protected map[string]string
+	// targetFolders holds the names of every target folder of the run.
+	targetFolders map[string]bool
+}
+
+func newCleaner(c clients, config Config, now time.Time) *cleaner {
+	runID := newRunID()
+	cl := &cleaner{
+		config:                 config,
+		now:                    now,
+		resourceCreationCutoff: now.Add(-time.Duration(config.MaxProjectAgeHours) * time.Hour),
+		log:                    logger.withRunID(runID),
+		report:                 newRunReport(runID, config, now),
+		projectSlots:           make(chan struct{}, max(config.ProjectParallelism, 1)),
+		caller:                 &apiCaller{limiter: newAPILimiter(config.MaxConcurrentAPICalls), policy: newRetryPolicy(config)},
+		sleep:                  time.Sleep,
+		clock:                  time.Now,
+		stop:                   &atomic.Bool{},
+	}
+	cl.caller.policy.onRetry = cl.retrying
+	cl.caller.policy.remaining = cl.remaining
+	cl.targetFolders = map[string]bool{}
+	for _, target := range config.targets() {
+		cl.targetFolders["folders/"+target.FolderId] = true
+	}
+	cl.clients = c.withCaller(cl.caller)
+	return cl
+}
+
+// The methods below record every action both in the log and in the run report.
+
+func (c *cleaner) listed(resourceType string, parent string, count int, err error) {
+	c.log.listed(resourceType, parent, count, err)
+	if err != nil {
+		c.report.addError(fmt.Errorf("list %ss in [%s]: %w", resourceTypeText(resourceType), parent, err))
+	}
+}
+
+func (c *cleaner) deleted(resourceType string, name string, err error) {
+	c.log.deleted(resourceType, name, err)
+	if err != nil {
+		c.report.addFailed(resourceType, name, err)
+	} else {
+		c.report.addDeleted(resourceType, name)
+	}
+}
+
+func (c *cleaner) skipped(resourceType string, name string, reason string) {
+	c.log.skipped(resourceType, name, reason)
+	c.report.addSkipped(resourceType, name, reason)
+}
+
+func (c *cleaner) deferred(resourceType string, name string, reason string) {
+	c.log.deferred(resourceType, name, reason)
+	c.report.addDeferred(resourceType, name, reason)
+}
+
+func (c *cleaner) running(resourceType string, name string, reason string) {
+	c.log.running(resourceType, name, reason)
+	c.report.addRunning(resourceType, name, reason)
+}
+
+func (c *cleaner) blocked(resourceType string, name string, reason string) {
+	c.log.blocked(resourceType, name, reason)
+	c.report.addBlocked(resourceType, name, reason)
+}
+
+func (c *cleaner) scheduled(resourceType string, name string, reason string, err error) {
+	c.log.scheduled(resourceType, name, reason, err)
+	if err != nil {
+		c.report.addError(fmt.Errorf("schedule deletion of %s [%s]: %w", resourceTypeText(resourceType), name, err))
+	} else {
+		c.report.addScheduled(resourceType, name, reason)
+	}
+}
+
+func (c *cleaner) retrying(api string, err error, delay time.Duration) {
+	c.log.retrying(api, err, delay)
+	c.report.addRetry(api)
+}
+
+func (c *cleaner) errorf(format string, v ...interface{}) {
+	err := fmt.Errorf(format, v...)
+	c.log.Errorf("%s", err.Error())
+	c.report.addError(err)
+}
+
+// skipInDryRun records the deletion that would have been made and reports whether the caller
+// must skip the mutating call.
+func (c *cleaner) skipInDryRun(resourceType string, name string) bool {
+	if !c.config.DryRun {
+		return false
+	}
+	c.log.planned(resourceType, name)
+	c.report.addPlanned(resourceType, name)
+	return true
+}
+
+// ageSkipReason returns why a resource created at createTime is too young to be deleted,
+// or an empty string if it is older than the cutoff.
+func (c *cleaner) ageSkipReason(createTime string) string {
+	createdAt, err := time.Parse(time.RFC3339, createTime)
+	if err != nil {
+		return fmt.Sprintf("failed to parse CreateTime [%s], error [%s]", createTime, err.Error())
+	}
+	if !createdAt.Before(c.resourceCreationCutoff) {
+		return "created after the age cutoff"
+	}
+	return ""
+}
+
+// projectAgeSkipReason returns why the project has not reached the end of its lifetime, or an
+// empty string if it has. The expires-at and ttl-hours labels take precedence, in that order,
+// over MaxProjectAgeHours.
+func (c *cleaner) projectAgeSkipReason(project *cloudresourcemanager.Project) string {
+	if value, ok := project.Labels[expiresAtLabel]; ok {
+		expiresAt, err := parseLabelTime(value)
+		if err != nil {
+			return fmt.Sprintf("invalid %s label, %s", expiresAtLabel, err.Error())
+		}
+		if c.now.Before(expiresAt) {
+			return fmt.Sprintf("%s label is %s", expiresAtLabel, expiresAt.Format(time.RFC3339))
+		}
+		return ""
+	}
+	if value, ok := project.Labels[ttlHoursLabel]; ok {
+		ttl, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ttl < 0 {
+			return fmt.Sprintf("invalid %s label [%s]", ttlHoursLabel, value)
+		}
+		createdAt, err := time.Parse(time.RFC3339, project.CreateTime)
+		if err != nil {
+			return fmt.Sprintf("failed to parse CreateTime [%s], error [%s]", project.CreateTime, err.Error())
+		}
+		if expiresAt := createdAt.Add(time.Duration(ttl) * time.Hour); c.now.Before(expiresAt) {
+			return fmt.Sprintf("%s label is %d, expires at %s", ttlHoursLabel, ttl, expiresAt.Format(time.RFC3339))
+		}
+		return ""
+	}
+	return c.ageSkipReason(project.CreateTime)
+}
+
+// gracePeriodElapsed implements the two-phase deletion. The first run matching the project stamps
+// the cleanup-scheduled-at and cleanup-scheduled labels on it, only a run after
+// DeletionGracePeriodHours deletes it. Owners can spare the project by excluding it, see
+// unschedule, or by removing the cleanup-scheduled-at label, see projectSkipReason.
+func (c *cleaner) gracePeriodElapsed(ctx context.Context, project *cloudresourcemanager.Project) bool {
+	if c.config.DeletionGracePeriodHours <= 0 {
+		return true
+	}
+	gracePeriod := time.Duration(c.config.DeletionGracePeriodHours) * time.Hour
+	if deleteAfter, scheduled := c.gracePeriodEnd(project); scheduled {
+		if !c.now.Before(deleteAfter) {
+			return true
+		}
+		c.deferred(resourceProject, project.ProjectId, fmt.Sprintf("deletion scheduled, grace period ends at %s", deleteAfter.Format(time.RFC3339)))
+		return false
+	}
+	reason := fmt.Sprintf("grace period ends at %s", c.now.Add(gracePeriod).Format(time.RFC3339))
+	if c.config.DryRun {
+		c.deferred(resourceProject, project.ProjectId, "dry run, would schedule deletion, "+reason)
+		return false
+	}
+	err := c.projects.UpdateProjectLabels(ctx, project.ProjectId, map[string]string{
+		cleanupScheduledAtLabel: strconv.FormatInt(c.now.Unix(), 10),
+		cleanupScheduledLabel:   "true",
+	})
+	c.scheduled(resourceProject, project.ProjectId, reason, err)
+	return false
+}
+
+// gracePeriodEnd returns when the grace period of the project ends, false if its deletion isn't
+// scheduled by a valid cleanup-scheduled-at label.
+func (c *cleaner) gracePeriodEnd(project *cloudresourcemanager.Project) (time.Time, bool) {
+	value, ok := project.Labels[cleanupScheduledAtLabel]
+	if !ok {
+		return time.Time{}, false
+	}
+	scheduledAt, err := parseLabelTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return scheduledAt.Add(time.Duration(c.config.DeletionGracePeriodHours) * time.Hour), true
+}
+
+// unschedule removes the two-phase deletion labels of a project kept by a filter, so that a
+// new grace period starts if the project matches again later, e.g. once an excluded label is
+// removed.
+func (c *cleaner) unschedule(ctx context.Context, project *cloudresourcemanager.Project) {
+	if _, ok := project.Labels[cleanupScheduledAtLabel]; !ok || c.config.DryRun || !activeProjectFilter(project) {
+		return
+	}
+	if err := c.projects.UpdateProjectLabels(ctx, project.ProjectId, nil, cleanupScheduledAtLabel, cleanupScheduledLabel); err != nil {
+		c.errorf("failed to remove the [%s] label of project [%s], error [%w]", cleanupScheduledAtLabel, project.ProjectId, err)
+		return
+	}
+	c.log.Printf("Removed the [%s] label of project [%s] kept by a filter, its grace period starts over if it matches again", cleanupScheduledAtLabel, project.ProjectId)
+}
+
+// projectSkipReason returns why the project must not be deleted, or an empty string if it
+// matches every filter.
+func (c *cleaner) projectSkipReason(project *cloudresourcemanager.Project) string {
+	if reason := c.protectedReason("projects/"+project.ProjectId, fmt.Sprintf("projects/%d", project.ProjectNumber)); reason != "" {
+		return reason
+	}
+	if !activeProjectFilter(project) {
+		return fmt.Sprintf("lifecycle state is %s", project.LifecycleState)
+	}
+	if reason := c.projectAgeSkipReason(project); reason != "" {
+		return reason
+	}
+	if _, scheduled := project.Labels[cleanupScheduledAtLabel]; !scheduled && project.Labels[cleanupScheduledLabel] == "true" {
+		return fmt.Sprintf("owner removed the [%s] label to opt out of the deletion", cleanupScheduledAtLabel)
+	}
+	if !checkIfAtLeastOneLabelPresentIfAny(project, c.config.IncludedLabels, false) {
+		return "none of the included labels present"
+	}
+	if checkIfAtLeastOneLabelPresentIfAny(project, c.config.ExcludedLabels, true) {
+		return "one of the excluded labels present"
+	}
+	if selector := c.config.IncludedLabelSelector; !selector.isEmpty() && !selector.matches(project.Labels) {
+		return fmt.Sprintf("labels do not match the included selector [%s]", selector)
+	}
+	if selector := c.config.ExcludedLabelSelector; !selector.isEmpty() && selector.matches(project.Labels) {
+		return fmt.Sprintf("labels match the excluded selector [%s]", selector)
+	}
+	if len(c.config.IncludedProjects) > 0 && matchingProjectIdentifier(project, c.config.IncludedProjects) == "" {
+		return "id, name and number do not match any of the included patterns"
+	}
+	if identifier := matchingProjectIdentifier(project, c.config.ExcludedProjects); identifier != "" {
+		return fmt.Sprintf("%s matches one of the excluded patterns", identifier)
+	}
+	return ""
+}
+
+// processProjects cleans up the projects matching every filter, up to ProjectParallelism at
+// a time, and returns once all of them are done how many of them are still active, i.e. were
+// neither deleted nor planned for deletion in dry run. No project is started once the run is
+// stopped.
+func (c *cleaner) processProjects(ctx context.Context, projects []*cloudresourcemanager.Project) int {
+	var wg sync.WaitGroup
+	var remaining atomic.Int64
+	for i, project := range projects {
+		if c.stopped() {
+			remaining.Add(int64(len(projects) - i))
+			break
+		}
+		if reason := c.projectSkipReason(project); reason != "" {
+			c.skipped(resourceProject, project.ProjectId, reason)
+			c.unschedule(ctx, project)
+			if activeProjectFilter(project) {
+				remaining.Add(1)
+			}
+			continue
+		}
+		c.projectSlots <- struct{}{}
+		wg.Add(1)
+		go func(project *cloudresourcemanager.Project) {
+			defer func() {
+				<-c.projectSlots
+				wg.Done()
+			}()
+			if reason, err := c.projectTagsSkipReason(ctx, project); reason != "" {
+				c.skipped(resourceProject, project.ProjectId, reason)
+				// A failure to list the tags is no reason to start the grace period over.
+				if err == nil {
+					c.unschedule(ctx, project)
+				}
+				remaining.Add(1)
+				return
+			}
+			if !c.removeProjectWithLiens(ctx, project) {
+				remaining.Add(1)
+			}
+		}(project)
+	}
+	wg.Wait()
+	return int(remaining.Load())
+}
+
+// projectTagsSkipReason returns why the project must not be deleted because of its effective
+// tags, including the ones inherited from its ancestors, or an empty string if it can be deleted.
+// The error is the one of listing the tags, the project is then skipped as its tags are unknown.
+func (c *cleaner) projectTagsSkipReason(ctx context.Context, project *cloudresourcemanager.Project) (string, error) {
+	if len(c.config.IncludedTags) == 0 && len(c.config.ExcludedTags) == 0 {
+		return "", nil
+	}
+	parent := fmt.Sprintf("//cloudresourcemanager.googleapis.com/projects/%d", project.ProjectNumber)
+	var tags []*cloudresourcemanager3.EffectiveTag
+	err := c.effectiveTags.ListEffectiveTags(ctx, parent, func(page *cloudresourcemanager3.ListEffectiveTagsResponse) error {
+		tags = append(tags, page.EffectiveTags...)
+		return nil
+	})
+	c.listed(resourceEffectiveTag, parent, len(tags), err)
+	if err != nil {
+		return "failed to list its effective tags", err
+	}
+	if len(c.config.IncludedTags) > 0 && findTag(tags, c.config.IncludedTags) == nil {
+		return "none of the included tags present", nil
+	}
+	if tag := findTag(tags, c.config.ExcludedTags); tag != nil {
+		if tag.Inherited {
+			return fmt.Sprintf("inherited tag [%s] is excluded", tag.NamespacedTagValue), nil
+		}
+		return fmt.Sprintf("tag [%s] is excluded", tag.NamespacedTagValue), nil
+	}
+	return "", nil
+}
+
+// removeLien removes the lien, name identifies it in the log and the report.
+func (c *cleaner) removeLien(ctx context.Context, name string, lien *cloudresourcemanager.Lien) {
+	if c.skipInDryRun(resourceLien, name) {
+		return
+	}
+	c.deleted(resourceLien, name, c.liens.DeleteLien(ctx, lien.Name))
+}
+
+// lienSkipReason returns why the lien must be kept, or an empty string if it can be removed.
+func (c *cleaner) lienSkipReason(lien *cloudresourcemanager.Lien) string {
+	for _, pattern := range c.config.PreservedLiens {
+		if pattern.MatchString(lien.Reason) || pattern.MatchString(lien.Origin) {
+			return fmt.Sprintf("origin [%s] or reason [%s] matches the preserved pattern [%s]", lien.Origin, lien.Reason, pattern)
+		}
+	}
+	if len(c.config.RemovableLienOrigins) > 0 && !checkIfNameIncluded(lien.Origin, c.config.RemovableLienOrigins) {
+		return fmt.Sprintf("origin [%s] is not one of the removable origins", lien.Origin)
+	}
+	return ""
+}
+
+func (c *cleaner) projectDeleteRequestedFilter(ctx context.Context, projectID string) bool {
+	p, err := c.projects.GetProject(ctx, projectID)
+	if err != nil {
+		c.errorf("failed to get project [%s], error [%w]", projectID, err)
+		return false
+	}
+	return p.LifecycleState == "DELETE_REQUESTED"
+}
+
+// deletedProjectResourceSkipReason returns why an organization level resource publishing to a
+// project must not be deleted, or an empty string if it can be deleted.
+func (c *cleaner) deletedProjectResourceSkipReason(ctx context.Context, name string, topic string, included []*regexp.Regexp) string {
+	if !checkIfNameIncluded(name, included) {
+		return "name does not match any of the included patterns"
+	}
+	projectID := strings.Split(topic, "/")[1]
+	if !c.projectDeleteRequestedFilter(ctx, projectID) {
+		return fmt.Sprintf("project [%s] is not deleted", projectID)
+	}
+	return ""
+}
+
+func (c *cleaner) removeSCCNotifications(ctx context.Context, organization string) {
+	parent := fmt.Sprintf("organizations/%s", organization)
+	req := &securitycenterpb.ListNotificationConfigsRequest{
+		Parent:   parent,
+		PageSize: c.config.SCCPageSize,
+	}
+	var configs []*securitycenterpb.NotificationConfig
+	err := c.sccNotifications.ListNotificationConfigs(ctx, req, func(resp *securitycenterpb.NotificationConfig) {
+		configs = append(configs, resp)
+	})
+	c.listed(resourceSCCNotification, parent, len(configs), err)
+	for _, resp := range configs {
+		if reason := c.deletedProjectResourceSkipReason(ctx, resp.Name, resp.PubsubTopic, c.config.IncludedSCCNotifications); reason != "" {
+			c.skipped(resourceSCCNotification, resp.Name, reason)
+			continue
+		}
+		if c.skipInDryRun(resourceSCCNotification, resp.Name) {
+			continue
+		}
+		c.deleted(resourceSCCNotification, resp.Name, c.sccNotifications.DeleteNotificationConfig(ctx, resp.Name))
+	}
+}
+
+func (c *cleaner) removeTagValues(ctx context.Context, tagKey string) {
+	var tagValues []*cloudresourcemanager3.TagValue
+	err := c.tagValues.ListTagValues(ctx, tagKey, c.config.TagValuesPageSize, func(page *cloudresourcemanager3.ListTagValuesResponse) error {
+		tagValues = append(tagValues, page.TagValues...)
+		return nil
+	})
+	c.listed(resourceTagValue, tagKey, len(tagValues), err)
+	for _, tagValue := range tagValues {
+		if c.skipInDryRun(resourceTagValue, tagValue.Name) {
+			continue
+		}
+		op, err := c.tagValues.DeleteTagValue(ctx, tagValue.Name)
+		c.completed(ctx, resourceTagValue, tagValue.Name, op, err)
+	}
+}
+
+// tagKeySkipReason returns why the tag key must not be deleted, or an empty string if it can be deleted.
+func (c *cleaner) tagKeySkipReason(tagKey *cloudresourcemanager3.TagKey) string {
+	if reason := c.protectedReason(tagKey.Name); reason != "" {
+		return reason
+	}
+	if checkIfTagKeyShortNameExcluded(tagKey.ShortName, c.config.ExcludedTagKeys) {
+		return fmt.Sprintf("short name [%s] is excluded", tagKey.ShortName)
+	}
+	return c.ageSkipReason(tagKey.CreateTime)
+}
+
+func (c *cleaner) removeTagKeys(ctx context.Context, organization string) {
+	parent := fmt.Sprintf("organizations/%s", organization)
+	var tagKeys []*cloudresourcemanager3.TagKey
+	err := c.tagKeys.ListTagKeys(ctx, parent, c.config.TagKeysPageSize, func(page *cloudresourcemanager3.ListTagKeysResponse) error {
+		tagKeys = append(tagKeys, page.TagKeys...)
+		return nil
+	})
+	c.listed(resourceTagKey, parent, len(tagKeys), err)
+	for _, tagKey := range tagKeys {
+		if reason := c.tagKeySkipReason(tagKey); reason != "" {
+			c.skipped(resourceTagKey, tagKey.Name, reason)
+			continue
+		}
+		c.removeTagValues(ctx, tagKey.Name)
+		if c.skipInDryRun(resourceTagKey, tagKey.Name) {
+			continue
+		}
+		op, err := c.tagKeys.DeleteTagKey(ctx, tagKey.Name)
+		c.completed(ctx, resourceTagKey, tagKey.Name, op, err)
+	}
+}
+
+func (c *cleaner) removeFeedsByName(ctx context.Context, organization string) {
+	parent := fmt.Sprintf("organizations/%s", organization)
+	resp, err := c.feeds.ListFeeds(ctx, parent)
+	if err != nil {
+		c.listed(resourceFeed, parent, 0, err)
+		return
+	}
+	c.listed(resourceFeed, parent, len(resp.Feeds), nil)
+
+	for _, feed := range resp.Feeds {
+		if reason := c.deletedProjectResourceSkipReason(ctx, feed.Name, feed.FeedOutputConfig.GetPubsubDestination().Topic, c.config.IncludedFeeds); reason != "" {
+			c.skipped(resourceFeed, feed.Name, reason)
+			continue
+		}
+		if c.skipInDryRun(resourceFeed, feed.Name) {
+			continue
+		}
+		c.deleted(resourceFeed, feed.Name, c.feeds.DeleteFeed(ctx, feed.Name))
+	}
+}
+
+// billingSinkSkipReason returns why the sink must not be deleted, or an empty string if it can be deleted.
+func (c *cleaner) billingSinkSkipReason(sink *logging.LogSink) string {
+	if reason := c.protectedReason(sink.ResourceName); reason != "" {
+		return reason
+	}
+	if sink.Name == "_Required" || sink.Name == "_Default" {
+		return "default sink"
+	}
+	if reason := c.ageSkipReason(sink.CreateTime); reason != "" {
+		return reason
+	}
+	if !checkIfNameIncluded(sink.ResourceName, c.config.TargetBillingSinks) {
+		return "name does not match any of the target patterns"
+	}
+	return ""
+}
+
+func (c *cleaner) removeBillingSinks(ctx context.Context, billing string) {
+	parent := fmt.Sprintf("billingAccounts/%s", billing)
+	var sinks []*logging.LogSink
+	err := c.billingSinks.ListBillingSinks(ctx, parent, c.config.BillingSinksPageSize, func(page *logging.ListSinksResponse) error {
+		sinks = append(sinks, page.Sinks...)
+		return nil
+	})
+	c.listed(resourceBillingSink, parent, len(sinks), err)
+	for _, sink := range sinks {
+		if reason := c.billingSinkSkipReason(sink); reason != "" {
+			c.skipped(resourceBillingSink, sink.ResourceName, reason)
+			continue
+		}
+		if c.skipInDryRun(resourceBillingSink, sink.ResourceName) {
+			continue
+		}
+		c.deleted(resourceBillingSink, sink.ResourceName, c.billingSinks.DeleteBillingSink(ctx, sink.ResourceName))
+	}
+}
+
+func (c *cleaner) removeFirewallPolicies(ctx context.Context, folder string) {
+	var policies []*compute.FirewallPolicy
+	err := c.firewallPolicies.ListFirewallPolicies(ctx, folder, c.config.FirewallPoliciesPageSize, func(page *compute.FirewallPolicyList) error {
+		policies = append(policies, page.Items...)
+		return nil
+	})
+	c.listed(resourceFirewallPolicy, folder, len(policies), err)
+	for _, policy := range policies {
+		for _, association := range policy.Associations {
+			associationName := fmt.Sprintf("%s/%s", policy.Name, association.Name)
+			if c.skipInDryRun(resourceFirewallPolicyAssociation, associationName) {
+				continue
+			}
+			op, err := c.firewallPolicies.RemoveFirewallPolicyAssociation(ctx, policy.Name, association.Name)
+			c.completed(ctx, resourceFirewallPolicyAssociation, associationName, op, err)
+		}
+		if c.skipInDryRun(resourceFirewallPolicy, policy.Name) {
+			continue
+		}
+		op, err := c.firewallPolicies.DeleteFirewallPolicy(ctx, policy.Name)
+		c.completed(ctx, resourceFirewallPolicy, policy.Name, op, err)
+	}
+}
+
+// clusterDeletion is the operation deleting a cluster.
+type clusterDeletion struct {
+	name string
+	op   *operation
+}
+
+// removeProjectClusters deletes the clusters of the project, running, degraded or in error,
+// and waits up to ClusterDeletionTimeout for them to be gone, including the ones whose deletion
+// started in an earlier run. It returns the number of clusters still being deleted or which
+// can't be deleted yet.
+func (c *cleaner) removeProjectClusters(ctx context.Context, projectId string) int {
+	parent := fmt.Sprintf("projects/%s/locations/-", projectId)
+	listResponse, err := c.clusters.ListClusters(ctx, parent)
+	if err != nil {
+		c.listed(resourceCluster, parent, 0, err)
+		return 0
+	}
+
+	c.listed(resourceCluster, parent, len(listResponse.Clusters), nil)
+	if len(listResponse.Clusters) == 0 {
+		return 0
+	}
+
+	var pendingDeletion int = 0
+	var deletions []clusterDeletion
+	for _, cluster := range listResponse.Clusters {
+		clusterName := fmt.Sprintf("projects/%s/locations/%s/clusters/%s", projectId, cluster.Location, cluster.Name)
+		switch clusterStatus := cluster.Status.String(); clusterStatus {
+		case "DEGRADED":
+			fallthrough
+		case "ERROR":
+			fallthrough
+		case "RUNNING":
+			if c.skipInDryRun(resourceCluster, clusterName) {
+				continue
+			}
+			op, err := c.clusters.DeleteCluster(ctx, clusterName)
+			if err != nil {
+				c.deleted(resourceCluster, clusterName, err)
+				continue
+			}
+			deletions = append(deletions, clusterDeletion{clusterName, op})
+		case "STOPPING":
+			op, err := c.clusters.DeletionOperation(ctx, clusterName)
+			if err != nil {
+				c.log.Printf("Failed to get the deletion operation of cluster [%s], error [%s]", clusterName, err.Error())
+			}
+			switch {
+			case op == nil:
+				c.deferred(resourceCluster, clusterName, fmt.Sprintf("status is %s", clusterStatus))
+				pendingDeletion++
+			case c.config.ClusterStoppingAlert > 0 && !op.started.IsZero() && c.now.Sub(op.started) >= c.config.ClusterStoppingAlert:
+				// The deletion started in an earlier run and still isn't done, it needs attention.
+				c.deleted(resourceCluster, clusterName, fmt.Errorf("stuck in %s for %s, deletion operation [%s] started at %s",
+					clusterStatus, c.now.Sub(op.started).Round(time.Minute), op.name, op.started.Format(time.RFC3339)))
+				pendingDeletion++
+			default:
+				deletions = append(deletions, clusterDeletion{clusterName, op})
+			}
+		case "PROVISIONING":
+			fallthrough
+		case "RECONCILING":
+			c.deferred(resourceCluster, clusterName, fmt.Sprintf("status is %s", clusterStatus))
+			pendingDeletion++
+		default:
+			c.skipped(resourceCluster, clusterName, fmt.Sprintf("status is %s", clusterStatus))
+		}
+	}
+
+	// The deletions run in parallel, so they share the time budget.
+	budget := c.config.ClusterDeletionTimeout
+	for _, deletion := range deletions {
+		outcome, waited := c.completedWithin(ctx, resourceCluster, deletion.name, deletion.op, nil, budget)
+		budget = max(budget-waited, 0)
+		if outcome == outcomeRunning {
+			pendingDeletion++
+		}
+	}
+	return pendingDeletion
+}
+
+func (c *cleaner) removeProjectEndpoints(ctx context.Context, projectId string) {
+	var services []*servicemanagement.ManagedService
+	err := c.serviceManagement.ListServices(ctx, projectId, c.config.EndpointsServicesPageSize, func(page *servicemanagement.ListServicesResponse) error {
+		services = append(services, page.Services...)
+		return nil
+	})
+	c.listed(resourceEndpointsService, projectId, len(services), err)
+	for _, service := range services {
+		op, err := c.serviceManagement.DeleteService(ctx, service.ServiceName)
+		c.completed(ctx, resourceEndpointsService, service.ServiceName, op, err)
+	}
+}
+
+// cleanupProjectById deletes the project and reports whether it was deleted, or planned for
+// deletion in dry run.
+func (c *cleaner) cleanupProjectById(ctx context.Context, projectId string) bool {
+	if clusters := c.removeProjectClusters(ctx, projectId); clusters != 0 {
+		c.deferred(resourceProject, projectId, fmt.Sprintf("%d clusters marked for deletion", clusters))
+		return false
+	}
+	if c.skipInDryRun(resourceProject, projectId) {
+		return true
+	}
+	err := c.projects.DeleteProject(ctx, projectId)
+	if err != nil {
+		c.log.Printf("Failed to delete project [%s], removing its Endpoints services and retrying, error [%s]", projectId, err.Error())
+		c.removeProjectEndpoints(ctx, projectId)
+		err = c.projects.DeleteProject(ctx, projectId)
+	}
+	c.deleted(resourceProject, projectId, err)
+	return err == nil
+}
+
+// removeProjectWithLiens removes the liens of the project and deletes it, it reports whether
+// the project was deleted, or planned for deletion in dry run.
+func (c *cleaner) removeProjectWithLiens(ctx context.Context, project *cloudresourcemanager.Project) bool {
+	projectId := project.ProjectId
+	parent := fmt.Sprintf("projects/%s", projectId)
+	var liens []*cloudresourcemanager.Lien
+	if err := c.liens.ListLiens(ctx, parent, func(page *cloudresourcemanager.ListLiensResponse) error {
+		liens = append(liens, page.Liens...)
+		return nil
+	}); err != nil {
+		c.listed(resourceLien, parent, 0, err)
+		return false
+	}
+	c.listed(resourceLien, parent, len(liens), nil)
+	var blocking []string
+	for _, lien := range liens {
+		if reason := c.lienSkipReason(lien); reason != "" {
+			name := fmt.Sprintf("%s/%s", parent, lien.Name)
+			c.skipped(resourceLien, name, reason)
+			blocking = append(blocking, name)
+		}
+	}
+	if len(blocking) > 0 {
+		c.skipped(resourceProject, projectId, fmt.Sprintf("held by liens [%s]", strings.Join(blocking, ", ")))
+		c.unschedule(ctx, project)
+		return false
+	}
+	if !c.gracePeriodElapsed(ctx, project) {
+		return false
+	}
+	blockers, host := c.projectBlockers(ctx, projectId)
+	if len(blockers) > 0 {
+		c.blocked(resourceProject, projectId, strings.Join(blockers, "; "))
+		return false
+	}
+	if host != nil && !c.disableSharedVPCHost(ctx, host) {
+		return false
+	}
+	for _, lien := range liens {
+		c.removeLien(ctx, fmt.Sprintf("%s/%s", parent, lien.Name), lien)
+	}
+	return c.cleanupProjectById(ctx, projectId)
+}
+
+func (c *cleaner) removeProjectsInFolder(ctx context.Context, folderId string) (int, error) {
+	return c.removeProjectsWithParent(ctx, "folder", strings.Replace(folderId, "folders/", "", 1))
+}
+
+// removeProjectsWithParent cleans up the projects directly below the folder or organization
+// and returns how many of them are still active.
+func (c *cleaner) removeProjectsWithParent(ctx context.Context, parentType string, parentId string) (int, error) {
+	requestFilter := fmt.Sprintf("parent.type:%s parent.id:%s", parentType, parentId)
+	var projects []*cloudresourcemanager.Project
+	err := c.projects.ListProjects(ctx, requestFilter, func(page *cloudresourcemanager.ListProjectsResponse) error {
+		projects = append(projects, page.Projects...)
+		return nil
+	})
+	c.listed(resourceProject, fmt.Sprintf("%ss/%s", parentType, parentId), len(projects), err)
+	return c.processProjects(ctx, projects), err
+}
+
+// folderSkipReason checks the folder, depth levels below the root folder, against the
+// folder filters. Its effective tags are only listed if every other filter passes.
+func (c *cleaner) folderSkipReason(ctx context.Context, folder *cloudresourcemanager2.Folder, depth int) string {
+	if reason := c.protectedReason(folder.Name); reason != "" {
+		return reason
+	}
+	if depth == 0 {
+		return "root folder"
+	}
+	if depth <= c.config.ProtectedFolderDepth {
+		return fmt.Sprintf("at depth %d below the root folder, folders up to depth %d are protected", depth, c.config.ProtectedFolderDepth)
+	}
+	if reason := c.ageSkipReason(folder.CreateTime); reason != "" {
+		return reason
+	}
+	if len(c.config.IncludedFolders) > 0 && !checkIfNameIncluded(folder.DisplayName, c.config.IncludedFolders) {
+		return fmt.Sprintf("display name [%s] does not match any of the included patterns", folder.DisplayName)
+	}
+	if checkIfNameIncluded(folder.DisplayName, c.config.ExcludedFolders) {
+		return fmt.Sprintf("display name [%s] matches one of the excluded patterns", folder.DisplayName)
+	}
+	if len(c.config.ExcludedFolderTags) == 0 {
+		return ""
+	}
+	parent := fmt.Sprintf("//cloudresourcemanager.googleapis.com/%s", folder.Name)
+	var tags []*cloudresourcemanager3.EffectiveTag
+	err := c.effectiveTags.ListEffectiveTags(ctx, parent, func(page *cloudresourcemanager3.ListEffectiveTagsResponse) error {
+		tags = append(tags, page.EffectiveTags...)
+		return nil
+	})
+	c.listed(resourceEffectiveTag, parent, len(tags), err)
+	if err != nil {
+		return "failed to list its effective tags"
+	}
+	if tag := findTag(tags, c.config.ExcludedFolderTags); tag != nil {
+		return fmt.Sprintf("tag [%s] is excluded", tag.NamespacedTagValue)
+	}
+	return ""
+}
+
+func (c *cleaner) removeFolder(ctx context.Context, folder *cloudresourcemanager2.Folder) bool {
+	folderId := folder.Name
+	c.removeFirewallPolicies(ctx, folderId)
+	if c.skipInDryRun(resourceFolder, folderId) {
+		return true
+	}
+	op, err := c.folders.DeleteFolder(ctx, folderId)
+	return c.completed(ctx, resourceFolder, folderId, op, err) == outcomeDeleted
+}
+
+// getSubFoldersAndRemoveProjectsFoldersRecursively cleans up the folder, depth levels below the
+// root folder, and everything below it, unless the checkpoint holds it as done. It reports
+// whether the folder was deleted, or planned for deletion in dry run.
+func (c *cleaner) getSubFoldersAndRemoveProjectsFoldersRecursively(ctx context.Context, folder *cloudresourcemanager2.Folder, depth int) bool {
+	if deleted, done := c.checkpoint.folder(folder.Name); done {
+		return deleted
+	}
+	deleted := c.removeFolderAndContent(ctx, folder, depth)
+	if !c.stop.Load() {
+		c.checkpoint.folderDone(folder.Name, deleted)
+	}
+	return deleted
+}
+
+// removeFolderAndContent cleans up the subfolders and projects of the folder and then the folder
+// itself. The folder is kept if the run stopped before everything below it was done.
+func (c *cleaner) removeFolderAndContent(ctx context.Context, folder *cloudresourcemanager2.Folder, depth int) bool {
+	folderId := folder.Name
+	var subFolders []*cloudresourcemanager2.Folder
+	err := c.folders.ListFolders(ctx, folderId, func(foldersResponse *cloudresourcemanager2.ListFoldersResponse) error {
+		subFolders = append(subFolders, foldersResponse.Folders...)
+		return nil
+	})
+	if err != nil {
+		c.listed(resourceFolder, folderId, 0, err)
+	}
+	remainingFolders := 0
+	for _, subFolder := range subFolders {
+		if c.stopped() || !c.getSubFoldersAndRemoveProjectsFoldersRecursively(ctx, subFolder, depth+1) {
+			remainingFolders++
+		}
+	}
+	if c.stopped() {
+		return false
+	}
+	remainingProjects, projectsErr := c.removeProjectsInFolder(ctx, folderId)
+	if c.stopped() {
+		return false
+	}
+	if err != nil {
+		c.skipped(resourceFolder, folderId, "failed to list its subfolders")
+		return false
+	}
+	if reason := c.folderSkipReason(ctx, folder, depth); reason != "" {
+		c.skipped(resourceFolder, folderId, reason)
+		return false
+	}
+	if c.config.FolderDeletionMode == folderDeletionEmpty {
+		if projectsErr != nil {
+			c.skipped(resourceFolder, folderId, "failed to list its projects")
+			return false
+		}
+		if remainingFolders > 0 || remainingProjects > 0 {
+			c.skipped(resourceFolder, folderId, fmt.Sprintf("not empty, %d folders and %d projects remain", remainingFolders, remainingProjects))
+			return false
+		}
+	}
+	return c.removeFolder(ctx, folder)
+}
+
+// withConfig returns a cleaner using config which shares the clients, log, report and
+// project slots of c.
+func (c *cleaner) withConfig(config Config) *cleaner {
+	target := *c
+	target.config = config
+	target.resourceCreationCutoff = c.now.Add(-time.Duration(config.MaxProjectAgeHours) * time.Hour)
+	return &target
+}
+
+// removeFolderTree cleans up the folder c.config.RootFolderId and everything below it.
+func (c *cleaner) removeFolderTree(ctx context.Context) {
+	c.log.Printf("Starting clean up of folder [%s], dry run [%t]", c.config.RootFolderId, c.config.DryRun)
+	rootFolderId := fmt.Sprintf("folders/%s", c.config.RootFolderId)
+	rootFolder, err := c.folders.GetFolder(ctx, rootFolderId)
+	if err != nil {
+		c.errorf("failed to get parent folder [%s], error [%w]", rootFolderId, err)
+		return
+	}
+	c.getSubFoldersAndRemoveProjectsFoldersRecursively(ctx, rootFolder, 0)
+}
+
+// run processes the target folder hierarchies followed by the enabled organization level clean ups
+// and returns the report of everything it did.
+func (c *cleaner) run(ctx context.Context) *runReport {
+	c.deadline = c.runDeadline(ctx)
+	if err := c.loadProtectedResources(ctx); err != nil {
+		c.errorf("skipping the run, the cleaner can't protect its host project, %w", err)
+		c.publishReport(ctx)
+		return c.report
+	}
+	c.loadCheckpoint(ctx)
+	for _, target := range c.config.targets() {
+		if c.stopped() {
+			break
+		}
+		c.withConfig(c.config.forTarget(target)).removeFolderTree(ctx)
+	}
+
+	if c.config.CleanUpOrganizationProjects {
+		c.runStep(ctx, stepOrganizationProjects, func(ctx context.Context) {
+			c.log.Printf("Starting clean up of projects in organization [%s], dry run [%t]", c.config.OrganizationId, c.config.DryRun)
+			c.removeProjectsWithParent(ctx, "organization", c.config.OrganizationId)
+		})
+	}
+
+	// Only Tag Keys whose values are not in use can be deleted.
+	if c.config.CleanUpTagKeys {
+		c.runStep(ctx, stepTagKeys, func(ctx context.Context) {
+			c.removeTagKeys(ctx, c.config.OrganizationId)
+		})
+	}
+
+	// only delete Security Command Center notifications from deleted projects
+	if c.config.CleanUpSCCNotifications {
+		c.runStep(ctx, stepSCCNotifications, func(ctx context.Context) {
+			c.removeSCCNotifications(ctx, c.config.OrganizationId)
+		})
+	}
+
+	// Only delete Feeds from deleted projects
+	if c.config.CleanUpCaiFeeds {
+		c.runStep(ctx, stepCaiFeeds, func(ctx context.Context) {
+			c.removeFeedsByName(ctx, c.config.OrganizationId)
+		})
+	}
+
+	if c.config.CleanUpBillingSinks {
+		c.runStep(ctx, stepBillingSinks, func(ctx context.Context) {
+			c.removeBillingSinks(ctx, c.config.BillingAccount)
+		})
+	}
+
+	c.saveCheckpoint(ctx)
+	c.publishReport(ctx)
+	return c.report
+}
+
+// publishReport logs the run report as a single entry, writes it to the configured sinks and
+// notifies about its project events.
+func (c *cleaner) publishReport(ctx context.Context) {
+	c.report.EndTime = time.Now()
+	c.log.summary(c.report)
+	c.writeReport(ctx)
+	c.notify(ctx)
+}
+
+// writeReport writes the JSON encoded run report to every report sink.
+func (c *cleaner) writeReport(ctx context.Context) {
+	if len(c.reportSinks) == 0 {
+		return
+	}
+	data, err := json.Marshal(c.report)
+	if err != nil {
+		c.log.Errorf("Failed to marshal run report, error [%s]", err.Error())
+		return
+	}
+	for _, sink := range c.reportSinks {
+		if err := sink.WriteReport(ctx, c.report, data); err != nil {
+			c.errorf("%w", err)
+		}
+	}
+}
+
+// notify sends the project events of the run to every notifier, runs which did nothing are not notified.
+func (c *cleaner) notify(ctx context.Context) {
+	n := newNotification(c.report)
+	if len(c.notifiers) == 0 || n.isEmpty() {
+		return
+	}
+	for _, notifier := range c.notifiers {
+		if err := notifier.Notify(ctx, n); err != nil {
+			c.errorf("%w", err)
+		}
+	}
+}
@@ -0,0 +1,915 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"cloud.google.com/go/container/apiv1/containerpb"
+	"cloud.google.com/go/securitycenter/apiv1/securitycenterpb"
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	cloudresourcemanager3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/servicemanagement/v1"
+	"google.golang.org/api/storage/v1"
+)
+
+const (
+	testRootFolderId = "100"
+	oldTime          = "2024-01-01T00:00:00Z"
+	newTime          = "2024-01-02T23:00:00Z"
+)
+
+var testNow = time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+
+func testConfig() Config {
+	return Config{
+		MaxProjectAgeHours:   24,
+		RootFolderId:         testRootFolderId,
+		OrganizationId:       "1",
+		ProtectedFolderDepth: 1,
+	}
+}
+
+func newTestCleaner(f *fakeCloud, config Config) *cleaner {
+	c := newCleaner(f.clients(), config, testNow)
+	c.sleep = func(time.Duration) {}
+	c.caller.policy.sleep = func(context.Context, time.Duration) error { return nil }
+	return c
+}
+
+// newTestHierarchy builds folders/100 > folders/200 > folders/300 with one old and one new
+// project in each folder below the root.
+func newTestHierarchy() *fakeCloud {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addFolder("200", "folders/100", oldTime)
+	f.addFolder("300", "folders/200", oldTime)
+	f.addProject("old-200", "200", oldTime, nil)
+	f.addProject("new-200", "200", newTime, nil)
+	f.addProject("old-300", "300", oldTime, nil)
+	f.addProject("new-300", "300", newTime, nil)
+	return f
+}
+
+// sameItems reports whether got and want hold the same report items, in any order.
+func sameItems(got []reportItem, want []reportItem) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for _, item := range want {
+		if !slices.Contains(got, item) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameStrings reports whether got and want hold the same strings, in any order.
+func sameStrings(got []string, want []string) bool {
+	got, want = append([]string{}, got...), append([]string{}, want...)
+	sort.Strings(got)
+	sort.Strings(want)
+	return reflect.DeepEqual(got, want)
+}
+
+func TestRunDeletesOldProjectsAndNestedFolders(t *testing.T) {
+	f := newTestHierarchy()
+	f.liens["projects/old-300"] = []*cloudresourcemanager.Lien{{Name: "liens/l1", Parent: "projects/old-300"}}
+
+	newTestCleaner(f, testConfig()).run(context.Background())
+
+	for _, projectId := range []string{"old-200", "old-300"} {
+		if f.projects[projectId].LifecycleState != "DELETE_REQUESTED" {
+			t.Errorf("project %s was not deleted", projectId)
+		}
+	}
+	for _, projectId := range []string{"new-200", "new-300"} {
+		if f.projects[projectId].LifecycleState != "ACTIVE" {
+			t.Errorf("project %s should not be deleted", projectId)
+		}
+	}
+	if !f.called("DeleteLien", "liens/l1") {
+		t.Errorf("lien liens/l1 was not removed")
+	}
+	if !f.called("DeleteFolder", "folders/300") {
+		t.Errorf("folder folders/300 was not deleted")
+	}
+	for _, folder := range []string{"folders/100", "folders/200"} {
+		if f.called("DeleteFolder", folder) {
+			t.Errorf("folder %s is the root or its direct child and should not be deleted", folder)
+		}
+	}
+}
+
+func TestRunAppliesLabelFilters(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addProject("ci", testRootFolderId, oldTime, map[string]string{"env": "ci"})
+	f.addProject("ci-keep", testRootFolderId, oldTime, map[string]string{"env": "ci", "keep": "true"})
+	f.addProject("prod", testRootFolderId, oldTime, map[string]string{"env": "prod"})
+
+	config := testConfig()
+	config.IncludedLabels = map[string]string{"env": "ci"}
+	config.ExcludedLabels = map[string]string{"keep": "true"}
+	newTestCleaner(f, config).run(context.Background())
+
+	if !f.called("DeleteProject", "ci") {
+		t.Errorf("project ci was not deleted")
+	}
+	for _, projectId := range []string{"ci-keep", "prod"} {
+		if f.called("DeleteProject", projectId) {
+			t.Errorf("project %s should not be deleted", projectId)
+		}
+	}
+}
+
+func TestRunAppliesLabelSelectors(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addProject("ci", testRootFolderId, oldTime, map[string]string{"env": "ci"})
+	f.addProject("test-owned", testRootFolderId, oldTime, map[string]string{"env": "test", "owner": "me"})
+	f.addProject("sandbox", testRootFolderId, oldTime, map[string]string{"sandbox": "true", "owner": "me"})
+	f.addProject("sandbox-keep", testRootFolderId, oldTime, map[string]string{"sandbox": "true", "keep": "forever"})
+
+	config := testConfig()
+	config.IncludedLabelSelector, _ = ParseLabelSelector("env in (ci,test), !owner || sandbox=true")
+	config.ExcludedLabelSelector, _ = ParseLabelSelector("keep=~forever|always")
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if got, want := report.resource(resourceProject).Deleted, []string{"ci", "sandbox"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+}
+
+func TestRunAppliesEffectiveTagFilters(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	for i, projectId := range []string{"ci", "protected", "untagged", "unknown"} {
+		f.addProject(projectId, testRootFolderId, oldTime, nil).ProjectNumber = int64(i + 1)
+	}
+	ci := &cloudresourcemanager3.EffectiveTag{NamespacedTagKey: "1/env", NamespacedTagValue: "1/env/ci"}
+	protected := &cloudresourcemanager3.EffectiveTag{NamespacedTagKey: "1/protected", NamespacedTagValue: "1/protected/true", Inherited: true}
+	f.effectiveTags["//cloudresourcemanager.googleapis.com/projects/1"] = []*cloudresourcemanager3.EffectiveTag{ci}
+	f.effectiveTags["//cloudresourcemanager.googleapis.com/projects/2"] = []*cloudresourcemanager3.EffectiveTag{ci, protected}
+	f.failures["ListEffectiveTags //cloudresourcemanager.googleapis.com/projects/4"] = errors.New("tags unavailable")
+
+	config := testConfig()
+	config.IncludedTags = []string{"1/env/ci"}
+	config.ExcludedTags = []string{"1/protected"}
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if got, want := report.resource(resourceProject).Deleted, []string{"ci"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+	reasons := map[string]string{}
+	for _, item := range report.resource(resourceProject).Skipped {
+		reasons[item.Name] = item.Reason
+	}
+	for projectId, want := range map[string]string{
+		"protected": "inherited tag [1/protected/true] is excluded",
+		"untagged":  "none of the included tags present",
+		"unknown":   "failed to list its effective tags",
+	} {
+		if reasons[projectId] != want {
+			t.Errorf("got skip reason %q for %s, want %q", reasons[projectId], projectId, want)
+		}
+	}
+}
+
+func TestRunAppliesLienPolicies(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	for _, projectId := range []string{"ci", "important", "foreign"} {
+		f.addProject(projectId, testRootFolderId, oldTime, nil)
+	}
+	f.liens["projects/ci"] = []*cloudresourcemanager.Lien{{Name: "liens/ci", Origin: "terraform", Reason: "created by CI"}}
+	f.liens["projects/important"] = []*cloudresourcemanager.Lien{
+		{Name: "liens/tf", Origin: "terraform", Reason: "created by CI"},
+		{Name: "liens/keep", Origin: "owner@example.com", Reason: "do-not-delete: shared data"},
+	}
+	f.liens["projects/foreign"] = []*cloudresourcemanager.Lien{{Name: "liens/foreign", Origin: "other-team", Reason: "in use"}}
+
+	config := testConfig()
+	config.PreservedLiens = []*regexp.Regexp{regexp.MustCompile("^do-not-delete")}
+	config.RemovableLienOrigins = []*regexp.Regexp{regexp.MustCompile("^terraform$")}
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if got, want := report.resource(resourceProject).Deleted, []string{"ci"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+	if got, want := report.resource(resourceLien).Deleted, []string{"projects/ci/liens/ci"}; !sameStrings(got, want) {
+		t.Errorf("got removed liens %v, want %v", got, want)
+	}
+	var blocking []string
+	for _, item := range report.resource(resourceLien).Skipped {
+		blocking = append(blocking, item.Name)
+	}
+	if want := []string{"projects/important/liens/keep", "projects/foreign/liens/foreign"}; !sameStrings(blocking, want) {
+		t.Errorf("got blocking liens %v, want %v", blocking, want)
+	}
+	if f.called("DeleteLien", "liens/tf") {
+		t.Errorf("liens of a preserved project should not be removed")
+	}
+}
+
+func TestRunHonoursProjectLifetimeLabels(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	// testNow is 2024-01-03, oldTime 2024-01-01 and newTime 2024-01-02T23:00.
+	f.addProject("expired", testRootFolderId, newTime, map[string]string{"expires-at": "2024-01-02"})
+	f.addProject("expired-unix", testRootFolderId, newTime, map[string]string{"expires-at": "1704200000"})
+	f.addProject("not-expired", testRootFolderId, oldTime, map[string]string{"expires-at": "2024-01-10"})
+	f.addProject("short-ttl", testRootFolderId, newTime, map[string]string{"ttl-hours": "1"})
+	f.addProject("long-ttl", testRootFolderId, oldTime, map[string]string{"ttl-hours": "168"})
+	f.addProject("invalid-ttl", testRootFolderId, oldTime, map[string]string{"ttl-hours": "week"})
+	f.addProject("global-age", testRootFolderId, oldTime, nil)
+
+	report := newTestCleaner(f, testConfig()).run(context.Background())
+
+	if got, want := report.resource(resourceProject).Deleted, []string{"expired", "expired-unix", "short-ttl", "global-age"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+}
+
+func TestRunDeletesProjectsAfterGracePeriod(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addProject("stale", testRootFolderId, oldTime, nil)
+	f.addProject("spared", testRootFolderId, oldTime, nil)
+	config := testConfig()
+	config.ExcludedLabels = map[string]string{"keep": "true"}
+	config.DeletionGracePeriodHours = 48
+
+	first := newTestCleaner(f, config).run(context.Background())
+	if first.resource(resourceProject).ScheduledCount != 2 || len(first.resource(resourceProject).Deleted) != 0 {
+		t.Fatalf("first run should only schedule the deletions, got %s", first.summary())
+	}
+	if got := f.projects["stale"].Labels[cleanupScheduledAtLabel]; got != strconv.FormatInt(testNow.Unix(), 10) {
+		t.Errorf("got %s label %q", cleanupScheduledAtLabel, got)
+	}
+
+	// The owner of spared excludes it during the grace period.
+	f.projects["spared"].Labels["keep"] = "true"
+	early := newCleaner(f.clients(), config, testNow.Add(24*time.Hour)).run(context.Background())
+	if early.resource(resourceProject).DeferredCount != 1 || len(early.resource(resourceProject).Deleted) != 0 {
+		t.Errorf("run within the grace period should defer the deletion, got %s", early.summary())
+	}
+
+	late := newCleaner(f.clients(), config, testNow.Add(49*time.Hour)).run(context.Background())
+	if got, want := late.resource(resourceProject).Deleted, []string{"stale"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+}
+
+func TestRunSparesProjectsWhoseScheduleLabelWasRemoved(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addProject("spared", testRootFolderId, oldTime, nil)
+	config := testConfig()
+	config.DeletionGracePeriodHours = 48
+	newTestCleaner(f, config).run(context.Background())
+
+	// The owner removes the label during the grace period.
+	delete(f.projects["spared"].Labels, cleanupScheduledAtLabel)
+	f.calls = nil
+	late := newCleaner(f.clients(), config, testNow.Add(49*time.Hour)).run(context.Background())
+
+	if len(f.calls) != 0 {
+		t.Errorf("a project whose owner opted out should be left untouched, calls %v", f.calls)
+	}
+	want := reportItem{Name: "spared", Reason: "owner removed the [cleanup-scheduled-at] label to opt out of the deletion"}
+	if !slices.Contains(late.resource(resourceProject).Skipped, want) {
+		t.Errorf("skipped projects %v do not contain %v", late.resource(resourceProject).Skipped, want)
+	}
+}
+
+func TestRunRestartsTheGracePeriodOfProjectsExcludedMeanwhile(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addProject("kept", testRootFolderId, oldTime, nil)
+	config := testConfig()
+	config.ExcludedLabels = map[string]string{"keep": "true"}
+	config.DeletionGracePeriodHours = 48
+	newTestCleaner(f, config).run(context.Background())
+
+	// The owner excludes the project during the grace period and removes the exclusion later.
+	f.projects["kept"].Labels["keep"] = "true"
+	newCleaner(f.clients(), config, testNow.Add(24*time.Hour)).run(context.Background())
+	if _, ok := f.projects["kept"].Labels[cleanupScheduledAtLabel]; ok {
+		t.Errorf("the %s label of an excluded project should be removed", cleanupScheduledAtLabel)
+	}
+	delete(f.projects["kept"].Labels, "keep")
+	later := newCleaner(f.clients(), config, testNow.Add(8*24*time.Hour)).run(context.Background())
+
+	if f.called("DeleteProject", "kept") {
+		t.Errorf("the project should get a new grace period instead of being deleted")
+	}
+	if got := later.resource(resourceProject).ScheduledCount; got != 1 {
+		t.Errorf("got %d scheduled projects, want 1", got)
+	}
+}
+
+func TestRunKeepsTheScheduleOfProjectsWhoseTagsCannotBeListed(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addProject("tagged", testRootFolderId, oldTime, nil).ProjectNumber = 5
+	config := testConfig()
+	config.ExcludedTags = []string{"1/protected"}
+	config.DeletionGracePeriodHours = 48
+	newTestCleaner(f, config).run(context.Background())
+	scheduledAt := f.projects["tagged"].Labels[cleanupScheduledAtLabel]
+
+	f.failures["ListEffectiveTags //cloudresourcemanager.googleapis.com/projects/5"] = errors.New("tags unavailable")
+	newCleaner(f.clients(), config, testNow.Add(24*time.Hour)).run(context.Background())
+
+	labels := f.projects["tagged"].Labels
+	if labels[cleanupScheduledAtLabel] != scheduledAt || scheduledAt == "" || labels[cleanupScheduledLabel] != "true" {
+		t.Errorf("a failure to list the tags should keep the schedule labels, got %v", labels)
+	}
+}
+
+func TestRunDefersProjectsWithClusters(t *testing.T) {
+	f := newTestHierarchy()
+	f.clusters["old-200"] = []*containerpb.Cluster{{Name: "gke", Location: "us-central1", Status: containerpb.Cluster_RUNNING}}
+
+	newTestCleaner(f, testConfig()).run(context.Background())
+
+	if !f.called("DeleteCluster", "projects/old-200/locations/us-central1/clusters/gke") {
+		t.Errorf("cluster was not deleted")
+	}
+	if f.called("DeleteProject", "old-200") {
+		t.Errorf("project old-200 should be deferred until its clusters are deleted")
+	}
+}
+
+func TestRunRemovesEndpointsWhenProjectDeletionFails(t *testing.T) {
+	f := newTestHierarchy()
+	f.services["old-200"] = []*servicemanagement.ManagedService{{ServiceName: "api.endpoints.old-200.cloud.goog"}}
+
+	newTestCleaner(f, testConfig()).run(context.Background())
+
+	if !f.called("DeleteService", "api.endpoints.old-200.cloud.goog") {
+		t.Errorf("Endpoints service was not deleted")
+	}
+	if f.projects["old-200"].LifecycleState != "DELETE_REQUESTED" {
+		t.Errorf("project old-200 was not deleted after removing its Endpoints services")
+	}
+}
+
+func TestRunReportsProjectsBlockedFromDeletion(t *testing.T) {
+	f := newTestHierarchy()
+	f.addProject("host", "200", oldTime, nil)
+	f.addFolder("900", "organizations/1", oldTime)
+	f.addProject("service-1", "900", oldTime, nil)
+	f.buckets["old-200"] = []*storage.Bucket{
+		{Name: "logs"},
+		{Name: "audit", RetentionPolicy: &storage.BucketRetentionPolicy{RetentionPeriod: 30 * 24 * 3600, IsLocked: true}},
+	}
+	f.xpnResources["host"] = []*compute.XpnResourceId{{Id: "service-1", Type: "PROJECT"}}
+	f.failures["ListBuckets old-300"] = &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "accessNotConfigured"}}}
+
+	report := newTestCleaner(f, testConfig()).run(context.Background())
+
+	if f.called("DeleteProject", "old-200") || f.called("DeleteProject", "host") {
+		t.Errorf("blocked projects should not be deleted, calls %v", f.calls)
+	}
+	if !f.called("DeleteProject", "old-300") {
+		t.Errorf("project old-300 without the Cloud Storage API enabled should be deleted")
+	}
+	want := []reportItem{
+		{Name: "old-200", Reason: "bucket [gs://audit] has a locked retention policy of 30 days"},
+		{Name: "host", Reason: "Shared VPC host of service projects which are kept [service-1: outside of the target folders]"},
+	}
+	if got := report.resource(resourceProject).Blocked; !sameItems(got, want) {
+		t.Errorf("got blocked projects %v, want %v", got, want)
+	}
+	if len(report.Errors) != 0 {
+		t.Errorf("blocked projects should not be errors, got %v", report.Errors)
+	}
+	if f.called("DisableXpnResource", "host/service-1") {
+		t.Errorf("service projects outside of the target folders should stay attached")
+	}
+}
+
+func TestRunKeepsServiceProjectsWhichAreNotDeleted(t *testing.T) {
+	scheduledAt := func(at time.Time) map[string]string {
+		return map[string]string{cleanupScheduledAtLabel: strconv.FormatInt(at.Unix(), 10), cleanupScheduledLabel: "true"}
+	}
+	for _, tc := range []struct {
+		name       string
+		modify     func(f *fakeCloud, config *Config)
+		wantReason string
+	}{
+		{name: "preserved lien", modify: func(f *fakeCloud, config *Config) {
+			f.liens["projects/old-300"] = []*cloudresourcemanager.Lien{{Name: "liens/keep", Origin: "owner@example.com", Reason: "do-not-delete: shared data"}}
+			config.PreservedLiens = []*regexp.Regexp{regexp.MustCompile("^do-not-delete")}
+		}, wantReason: "old-300: held by liens [projects/old-300/liens/keep]"},
+		{name: "excluded tag", modify: func(f *fakeCloud, config *Config) {
+			f.projects["old-300"].ProjectNumber = 3
+			f.effectiveTags["//cloudresourcemanager.googleapis.com/projects/3"] = []*cloudresourcemanager3.EffectiveTag{{NamespacedTagKey: "1/protected", NamespacedTagValue: "1/protected/true"}}
+			config.ExcludedTags = []string{"1/protected"}
+		}, wantReason: "old-300: tag [1/protected/true] is excluded"},
+		{name: "grace period", modify: func(f *fakeCloud, config *Config) {
+			f.projects["host"].Labels = scheduledAt(testNow.Add(-72 * time.Hour))
+			f.projects["old-300"].Labels = scheduledAt(testNow.Add(-time.Hour))
+			config.DeletionGracePeriodHours = 48
+		}, wantReason: "old-300: deletion scheduled, grace period ends at 2024-01-04T23:00:00Z"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestHierarchy()
+			f.addProject("host", "300", oldTime, nil)
+			f.xpnResources["host"] = []*compute.XpnResourceId{{Id: "old-300", Type: "PROJECT"}}
+			config := testConfig()
+			tc.modify(f, &config)
+
+			report := newTestCleaner(f, config).run(context.Background())
+
+			for _, call := range []string{"DisableXpnResource host/old-300", "DisableXpnHost host", "DeleteProject host", "DeleteProject old-300"} {
+				if slices.Contains(f.calls, call) {
+					t.Errorf("calls %v should not contain %s", f.calls, call)
+				}
+			}
+			want := reportItem{Name: "host", Reason: fmt.Sprintf("Shared VPC host of service projects which are kept [%s]", tc.wantReason)}
+			if got := report.resource(resourceProject).Blocked; !slices.Contains(got, want) {
+				t.Errorf("blocked projects %v do not contain %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRunDisablesSharedVPCHostsBeforeDeletingThem(t *testing.T) {
+	f := newTestHierarchy()
+	f.addProject("host", "300", oldTime, nil)
+	f.xpnResources["host"] = []*compute.XpnResourceId{{Id: "old-300", Type: "PROJECT"}}
+
+	report := newTestCleaner(f, testConfig()).run(context.Background())
+
+	for _, call := range []string{"DisableXpnResource host/old-300", "DisableXpnHost host", "DeleteProject host"} {
+		if !slices.Contains(f.calls, call) {
+			t.Errorf("calls %v do not contain %s", f.calls, call)
+		}
+	}
+	if slices.Index(f.calls, "DisableXpnHost host") > slices.Index(f.calls, "DeleteProject host") {
+		t.Errorf("the host project should be disabled before it is deleted, calls %v", f.calls)
+	}
+	if got, want := report.resource(resourceSharedVPCServiceProject).Deleted, []string{"host/old-300"}; !sameStrings(got, want) {
+		t.Errorf("got detached service projects %v, want %v", got, want)
+	}
+	if len(report.Errors) != 0 {
+		t.Errorf("got errors %v", report.Errors)
+	}
+}
+
+func TestRunRemovesFolderFirewallPolicies(t *testing.T) {
+	f := newTestHierarchy()
+	f.firewallPolicies["folders/300"] = []*compute.FirewallPolicy{{
+		Name:         "123",
+		Associations: []*compute.FirewallPolicyAssociation{{Name: "assoc"}},
+	}}
+
+	newTestCleaner(f, testConfig()).run(context.Background())
+
+	if !f.called("RemoveFirewallPolicyAssociation", "123/assoc") || !f.called("DeleteFirewallPolicy", "123") {
+		t.Errorf("firewall policy was not removed, calls %v", f.calls)
+	}
+}
+
+func TestRunCleansUpOrganizationLevelResources(t *testing.T) {
+	f := newTestHierarchy()
+	f.projects["deleted"] = &cloudresourcemanager.Project{ProjectId: "deleted", LifecycleState: "DELETE_REQUESTED"}
+	f.tagKeys = []*cloudresourcemanager3.TagKey{
+		{Name: "tagKeys/1", ShortName: "old", CreateTime: oldTime},
+		{Name: "tagKeys/2", ShortName: "excluded", CreateTime: oldTime},
+		{Name: "tagKeys/3", ShortName: "new", CreateTime: newTime},
+	}
+	f.tagValues["tagKeys/1"] = []*cloudresourcemanager3.TagValue{{Name: "tagValues/1"}}
+	f.notificationConfigs = []*securitycenterpb.NotificationConfig{
+		{Name: "organizations/1/notificationConfigs/scc-1", PubsubTopic: "projects/deleted/topics/t"},
+		{Name: "organizations/1/notificationConfigs/scc-2", PubsubTopic: "projects/old-200-unknown/topics/t"},
+	}
+	f.feeds = []*assetpb.Feed{{
+		Name: "organizations/1/feeds/fd-1",
+		FeedOutputConfig: &assetpb.FeedOutputConfig{Destination: &assetpb.FeedOutputConfig_PubsubDestination{
+			PubsubDestination: &assetpb.PubsubDestination{Topic: "projects/deleted/topics/t"},
+		}},
+	}}
+	f.billingSinks = []*logging.LogSink{
+		{Name: "_Default", ResourceName: "billingAccounts/A/sinks/_Default", CreateTime: oldTime},
+		{Name: "sk-1", ResourceName: "billingAccounts/A/sinks/sk-1", CreateTime: oldTime},
+	}
+
+	config := testConfig()
+	config.CleanUpTagKeys = true
+	config.ExcludedTagKeys = []string{"excluded"}
+	config.CleanUpSCCNotifications = true
+	config.IncludedSCCNotifications = []*regexp.Regexp{regexp.MustCompile(".*/notificationConfigs/scc-.*")}
+	config.CleanUpCaiFeeds = true
+	config.IncludedFeeds = []*regexp.Regexp{regexp.MustCompile(".*/feeds/fd-.*")}
+	config.CleanUpBillingSinks = true
+	config.BillingAccount = "A"
+	config.TargetBillingSinks = []*regexp.Regexp{regexp.MustCompile(".*")}
+	newTestCleaner(f, config).run(context.Background())
+
+	for _, call := range [][2]string{
+		{"DeleteTagValue", "tagValues/1"},
+		{"DeleteTagKey", "tagKeys/1"},
+		{"DeleteNotificationConfig", "organizations/1/notificationConfigs/scc-1"},
+		{"DeleteFeed", "organizations/1/feeds/fd-1"},
+		{"DeleteBillingSink", "billingAccounts/A/sinks/sk-1"},
+	} {
+		if !f.called(call[0], call[1]) {
+			t.Errorf("expected %s %s, calls %v", call[0], call[1], f.calls)
+		}
+	}
+	for _, call := range [][2]string{
+		{"DeleteTagKey", "tagKeys/2"},
+		{"DeleteTagKey", "tagKeys/3"},
+		{"DeleteNotificationConfig", "organizations/1/notificationConfigs/scc-2"},
+		{"DeleteBillingSink", "billingAccounts/A/sinks/_Default"},
+	} {
+		if f.called(call[0], call[1]) {
+			t.Errorf("unexpected %s %s", call[0], call[1])
+		}
+	}
+}
+
+func TestRunPagesThroughEveryList(t *testing.T) {
+	f := newTestHierarchy()
+	f.tagKeys = []*cloudresourcemanager3.TagKey{
+		{Name: "tagKeys/1", ShortName: "one", CreateTime: oldTime},
+		{Name: "tagKeys/2", ShortName: "two", CreateTime: oldTime},
+	}
+	f.tagValues["tagKeys/1"] = []*cloudresourcemanager3.TagValue{{Name: "tagValues/1"}, {Name: "tagValues/2"}}
+	f.billingSinks = []*logging.LogSink{
+		{Name: "sk-1", ResourceName: "billingAccounts/A/sinks/sk-1", CreateTime: oldTime},
+		{Name: "sk-2", ResourceName: "billingAccounts/A/sinks/sk-2", CreateTime: oldTime},
+	}
+	f.firewallPolicies["folders/300"] = []*compute.FirewallPolicy{{Name: "1"}, {Name: "2"}}
+	f.services["old-200"] = []*servicemanagement.ManagedService{{ServiceName: "one.endpoints.old-200.cloud.goog"}, {ServiceName: "two.endpoints.old-200.cloud.goog"}}
+
+	config := testConfig()
+	config.CleanUpTagKeys = true
+	config.CleanUpBillingSinks = true
+	config.BillingAccount = "A"
+	config.TargetBillingSinks = []*regexp.Regexp{regexp.MustCompile(".*")}
+	config.TagKeysPageSize = 1
+	config.TagValuesPageSize = 1
+	config.BillingSinksPageSize = 1
+	config.FirewallPoliciesPageSize = 1
+	config.EndpointsServicesPageSize = 1
+	newTestCleaner(f, config).run(context.Background())
+
+	for _, call := range [][2]string{
+		{"DeleteTagKey", "tagKeys/2"},
+		{"DeleteTagValue", "tagValues/2"},
+		{"DeleteBillingSink", "billingAccounts/A/sinks/sk-2"},
+		{"DeleteFirewallPolicy", "2"},
+		{"DeleteService", "two.endpoints.old-200.cloud.goog"},
+	} {
+		if !f.called(call[0], call[1]) {
+			t.Errorf("expected %s %s from the second page, calls %v", call[0], call[1], f.calls)
+		}
+	}
+}
+
+func TestRunGoesOnAfterAListingError(t *testing.T) {
+	f := newTestHierarchy()
+	f.tagKeys = []*cloudresourcemanager3.TagKey{
+		{Name: "tagKeys/1", ShortName: "one", CreateTime: oldTime},
+		{Name: "tagKeys/2", ShortName: "two", CreateTime: oldTime},
+	}
+	f.notificationConfigs = []*securitycenterpb.NotificationConfig{
+		{Name: "organizations/1/notificationConfigs/scc-1", PubsubTopic: "projects/deleted/topics/t"},
+		{Name: "organizations/1/notificationConfigs/scc-2", PubsubTopic: "projects/deleted/topics/t"},
+	}
+	f.projects["deleted"] = &cloudresourcemanager.Project{ProjectId: "deleted", LifecycleState: "DELETE_REQUESTED"}
+	f.failures["ListTagKeys organizations/1"] = errors.New("invalid page token")
+	f.failures["ListNotificationConfigs organizations/1"] = errors.New("invalid page token")
+
+	config := testConfig()
+	config.CleanUpTagKeys = true
+	config.TagKeysPageSize = 1
+	config.CleanUpSCCNotifications = true
+	config.IncludedSCCNotifications = []*regexp.Regexp{regexp.MustCompile(".*")}
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if !f.called("DeleteTagKey", "tagKeys/1") || !f.called("DeleteNotificationConfig", "organizations/1/notificationConfigs/scc-1") {
+		t.Errorf("the resources listed before the error should be cleaned up, calls %v", f.calls)
+	}
+	if len(report.Errors) != 2 {
+		t.Errorf("got errors %v, want both listing errors", report.Errors)
+	}
+}
+
+func TestRunDryRunDoesNotMutate(t *testing.T) {
+	f := newTestHierarchy()
+	f.liens["projects/old-300"] = []*cloudresourcemanager.Lien{{Name: "liens/l1", Parent: "projects/old-300"}}
+
+	config := testConfig()
+	config.DryRun = true
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if len(f.calls) != 0 {
+		t.Errorf("dry run made mutating calls %v", f.calls)
+	}
+	want := map[string][]string{
+		resourceLien:    {"projects/old-300/liens/l1"},
+		resourceProject: {"old-300", "old-200"},
+		resourceFolder:  {"folders/300"},
+	}
+	for resourceType, planned := range want {
+		if !reflect.DeepEqual(report.resource(resourceType).Planned, planned) {
+			t.Errorf("got planned %s deletions %v, want %v", resourceType, report.resource(resourceType).Planned, planned)
+		}
+	}
+}
+
+func TestRunReportsOutcomes(t *testing.T) {
+	f := newTestHierarchy()
+	f.addProject("failing", "200", oldTime, nil)
+	f.failures["DeleteProject failing"] = errors.New("permission denied")
+	f.clusters["old-300"] = []*containerpb.Cluster{{Name: "gke", Location: "us-central1", Status: containerpb.Cluster_STOPPING}}
+	sink := &recordingReportSink{}
+	f.reportSinks = []reportSink{sink}
+
+	report := newTestCleaner(f, testConfig()).run(context.Background())
+
+	projects := report.resource(resourceProject)
+	if !reflect.DeepEqual(projects.Deleted, []string{"old-200"}) {
+		t.Errorf("got deleted projects %v", projects.Deleted)
+	}
+	if projects.DeferredCount != 1 || projects.Deferred[0].Name != "old-300" {
+		t.Errorf("got deferred projects %v", projects.Deferred)
+	}
+	if projects.SkippedCount != 2 {
+		t.Errorf("got skipped projects %v", projects.Skipped)
+	}
+	if projects.FailedCount != 1 || projects.Failed[0].Name != "failing" {
+		t.Errorf("got failed projects %v", projects.Failed)
+	}
+	if len(report.Errors) != 1 {
+		t.Errorf("got errors %v", report.Errors)
+	}
+	if len(sink.reports) != 1 {
+		t.Fatalf("got %d reports written to the sink, want 1", len(sink.reports))
+	}
+	var written runReport
+	if err := json.Unmarshal(sink.reports[0], &written); err != nil {
+		t.Fatalf("report is not JSON: %v", err)
+	}
+	if written.RunID != report.RunID || written.Resources[resourceProject].DeletedCount != 1 {
+		t.Errorf("got written report %+v", written)
+	}
+}
+
+func TestRunAggregatesErrorsAndContinues(t *testing.T) {
+	f := newTestHierarchy()
+	f.failures["ListFolders folders/300"] = errors.New("subfolders unavailable")
+	f.failures["DeleteProject old-200"] = errors.New("permission denied")
+	f.addFolder("400", "folders/100", oldTime)
+	f.addFolder("500", "folders/400", oldTime)
+
+	err := newTestCleaner(f, testConfig()).run(context.Background()).err()
+
+	if err == nil {
+		t.Fatal("expected the run to return an error")
+	}
+	for _, want := range []string{"subfolders unavailable", "permission denied", "[2] errors"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err.Error(), want)
+		}
+	}
+	if !f.called("DeleteFolder", "folders/500") {
+		t.Errorf("folders after the failing one were not processed")
+	}
+	if f.called("DeleteFolder", "folders/300") {
+		t.Errorf("folder folders/300 should not be deleted when its subfolders can't be listed")
+	}
+}
+
+func TestRunCleansUpEveryTarget(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder("100", "organizations/1", oldTime)
+	f.addFolder("200", "organizations/1", oldTime)
+	f.addProject("old-100", "100", oldTime, nil)
+	f.addProject("old-200", "200", oldTime, nil)
+	f.addProject("old-200-pr", "200", oldTime, map[string]string{"env": "pr"})
+	f.addProject("old-org", "1", oldTime, nil).Parent.Type = "organization"
+	f.addProject("new-org", "1", newTime, nil).Parent.Type = "organization"
+	config := testConfig()
+	config.TargetFolders = []TargetFolder{{FolderId: "200", projectFilters: projectFilters{IncludedLabels: map[string]string{"env": "pr"}}}}
+	config.CleanUpOrganizationProjects = true
+
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if got, want := report.resource(resourceProject).Deleted, []string{"old-100", "old-200-pr", "old-org"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+	if !sameStrings(report.TargetFolderIds, []string{"100", "200"}) || !report.OrganizationProjects {
+		t.Errorf("report does not list the targets, got %v, %t", report.TargetFolderIds, report.OrganizationProjects)
+	}
+}
+
+func TestRunFiltersProjectsOnIdNameAndNumber(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addProject("ci-a1b2c3", testRootFolderId, oldTime, nil)
+	f.addProject("ci-shared", testRootFolderId, oldTime, nil)
+	f.addProject("ci-d4e5f6", testRootFolderId, oldTime, nil).ProjectNumber = 42
+	f.addProject("ci-g7h8i9", testRootFolderId, oldTime, nil).Name = "Shared CI"
+	f.addProject("prod", testRootFolderId, oldTime, nil)
+	config := testConfig()
+	config.IncludedProjects = []*regexp.Regexp{regexp.MustCompile(`^ci-[a-z0-9]{6}$`)}
+	config.ExcludedProjects = []*regexp.Regexp{regexp.MustCompile(`^42$`), regexp.MustCompile(`(?i)shared`)}
+
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if got, want := report.resource(resourceProject).Deleted, []string{"ci-a1b2c3"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+	reasons := map[string]string{}
+	for _, item := range report.resource(resourceProject).Skipped {
+		reasons[item.Name] = item.Reason
+	}
+	for projectId, want := range map[string]string{
+		"ci-shared": "id [ci-shared] matches one of the excluded patterns",
+		"ci-d4e5f6": "number [42] matches one of the excluded patterns",
+		"ci-g7h8i9": "name [Shared CI] matches one of the excluded patterns",
+		"prod":      "id, name and number do not match any of the included patterns",
+	} {
+		if reasons[projectId] != want {
+			t.Errorf("got skip reason %q for %s, want %q", reasons[projectId], projectId, want)
+		}
+	}
+}
+
+func TestRunFiltersFolders(t *testing.T) {
+	f := newFakeCloud()
+	f.addFolder(testRootFolderId, "organizations/1", oldTime)
+	f.addFolder("200", "folders/100", oldTime)
+	for id, displayName := range map[string]string{
+		"300": "ci-empty",
+		"400": "ci-keep",
+		"500": "ci-tagged",
+		"600": "ci-busy",
+		"700": "manual",
+		"800": "ci-cleaned",
+	} {
+		f.addFolder(id, "folders/200", oldTime).DisplayName = displayName
+	}
+	f.effectiveTags["//cloudresourcemanager.googleapis.com/folders/500"] = []*cloudresourcemanager3.EffectiveTag{
+		{NamespacedTagKey: "1/protected", NamespacedTagValue: "1/protected/true"},
+	}
+	f.addProject("new-600", "600", newTime, nil)
+	f.addProject("old-800", "800", oldTime, nil)
+	config := testConfig()
+	config.IncludedFolders = []*regexp.Regexp{regexp.MustCompile("^ci-")}
+	config.ExcludedFolders = []*regexp.Regexp{regexp.MustCompile("keep")}
+	config.ExcludedFolderTags = []string{"1/protected"}
+	config.FolderDeletionMode = folderDeletionEmpty
+
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if got, want := report.resource(resourceFolder).Deleted, []string{"folders/300", "folders/800"}; !sameStrings(got, want) {
+		t.Errorf("got deleted folders %v, want %v", got, want)
+	}
+	reasons := map[string]string{}
+	for _, item := range report.resource(resourceFolder).Skipped {
+		reasons[item.Name] = item.Reason
+	}
+	for folder, want := range map[string]string{
+		"folders/100": "root folder",
+		"folders/200": "at depth 1 below the root folder, folders up to depth 1 are protected",
+		"folders/400": "display name [ci-keep] matches one of the excluded patterns",
+		"folders/500": "tag [1/protected/true] is excluded",
+		"folders/600": "not empty, 0 folders and 1 projects remain",
+		"folders/700": "display name [manual] does not match any of the included patterns",
+	} {
+		if reasons[folder] != want {
+			t.Errorf("got skip reason %q for %s, want %q", reasons[folder], folder, want)
+		}
+	}
+}
+
+func TestRunIsSkippedWhenTheHostProjectCannotBeProtected(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		hostId    string
+		failures  map[string]error
+		wantError string
+	}{
+		{name: "missing host project", hostId: "gone", wantError: "failed to get the host project [gone]"},
+		{name: "unresolved ancestor", hostId: "host", failures: map[string]error{"GetFolder folders/400": errors.New("permission denied")}, wantError: "failed to get folder [folders/400]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestHierarchy()
+			f.addFolder("400", "folders/300", oldTime)
+			f.addProject("host", "400", oldTime, nil)
+			for call, err := range tc.failures {
+				f.failures[call] = err
+			}
+
+			config := testConfig()
+			config.HostProjectId = tc.hostId
+			report := newTestCleaner(f, config).run(context.Background())
+
+			for _, call := range f.calls {
+				if strings.HasPrefix(call, "Delete") {
+					t.Errorf("nothing should be deleted, got calls %v", f.calls)
+					break
+				}
+			}
+			if len(report.Errors) != 1 || !strings.Contains(report.Errors[0], tc.wantError) {
+				t.Errorf("got errors %v, want one containing %q", report.Errors, tc.wantError)
+			}
+		})
+	}
+}
+
+func TestRunProtectsFoldersUpToTheConfiguredDepth(t *testing.T) {
+	for _, tc := range []struct {
+		depth int
+		want  []string
+	}{
+		{depth: 0, want: []string{"folders/200", "folders/300"}},
+		{depth: 1, want: []string{"folders/300"}},
+		{depth: 2, want: nil},
+	} {
+		f := newTestHierarchy()
+		config := testConfig()
+		config.ProtectedFolderDepth = tc.depth
+
+		report := newTestCleaner(f, config).run(context.Background())
+
+		if got := report.resource(resourceFolder).Deleted; !sameStrings(got, tc.want) {
+			t.Errorf("depth %d: got deleted folders %v, want %v", tc.depth, got, tc.want)
+		}
+	}
+}
+
+func TestRunProtectsTheHostProjectAndTheProtectedResources(t *testing.T) {
+	f := newTestHierarchy()
+	f.addFolder("400", "folders/300", oldTime)
+	f.addProject("host", "400", oldTime, nil).ProjectNumber = 42
+	f.tagKeys = []*cloudresourcemanager3.TagKey{
+		{Name: "tagKeys/1", ShortName: "one", CreateTime: oldTime},
+		{Name: "tagKeys/2", ShortName: "two", CreateTime: oldTime},
+	}
+
+	config := testConfig()
+	config.ProtectedFolderDepth = 0
+	config.HostProjectId = "host"
+	config.ProtectedResources = []string{"projects/old-200", "tagKeys/1"}
+	config.CleanUpTagKeys = true
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if got, want := report.resource(resourceProject).Deleted, []string{"old-300"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+	if got := report.resource(resourceFolder).Deleted; len(got) != 0 {
+		t.Errorf("the ancestors of the host project should not be deleted, got %v", got)
+	}
+	if got, want := report.resource(resourceTagKey).Deleted, []string{"tagKeys/2"}; !sameStrings(got, want) {
+		t.Errorf("got deleted tag keys %v, want %v", got, want)
+	}
+	for _, want := range []reportItem{
+		{Name: "host", Reason: "protected, hosts the cleaner"},
+		{Name: "old-200", Reason: "protected, listed in [PROTECTED_RESOURCES]"},
+	} {
+		if !slices.Contains(report.resource(resourceProject).Skipped, want) {
+			t.Errorf("skipped projects %v do not contain %v", report.resource(resourceProject).Skipped, want)
+		}
+	}
+	if want := (reportItem{Name: "folders/300", Reason: "protected, ancestor of the host project [host] of the cleaner"}); !slices.Contains(report.resource(resourceFolder).Skipped, want) {
+		t.Errorf("skipped folders %v do not contain %v", report.resource(resourceFolder).Skipped, want)
+	}
+}
@@ -0,0 +1,513 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	asset "cloud.google.com/go/asset/apiv1"
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	container "cloud.google.com/go/container/apiv1"
+	"cloud.google.com/go/container/apiv1/containerpb"
+	securitycenter "cloud.google.com/go/securitycenter/apiv1"
+	"cloud.google.com/go/securitycenter/apiv1/securitycenterpb"
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	cloudresourcemanager2 "google.golang.org/api/cloudresourcemanager/v2"
+	cloudresourcemanager3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/servicemanagement/v1"
+	"google.golang.org/api/storage/v1"
+)
+
+// The interfaces below are the narrow subset of the Google Cloud APIs used by the cleaner.
+// Production code wraps the generated clients with the adapters at the end of this file,
+// tests provide in-memory implementations. Delete calls of APIs with long-running operations
+// return the operation, see waitForOperation.
+
+type projectsClient interface {
+	ListProjects(ctx context.Context, filter string, page func(*cloudresourcemanager.ListProjectsResponse) error) error
+	GetProject(ctx context.Context, projectId string) (*cloudresourcemanager.Project, error)
+	DeleteProject(ctx context.Context, projectId string) error
+	// UpdateProjectLabels adds or replaces the labels in set and removes the labels in remove,
+	// keeping the other labels of the project.
+	UpdateProjectLabels(ctx context.Context, projectId string, set map[string]string, remove ...string) error
+}
+
+type liensClient interface {
+	ListLiens(ctx context.Context, parent string, page func(*cloudresourcemanager.ListLiensResponse) error) error
+	DeleteLien(ctx context.Context, name string) error
+}
+
+type foldersClient interface {
+	GetFolder(ctx context.Context, name string) (*cloudresourcemanager2.Folder, error)
+	ListFolders(ctx context.Context, parent string, page func(*cloudresourcemanager2.ListFoldersResponse) error) error
+	DeleteFolder(ctx context.Context, name string) (*operation, error)
+}
+
+type tagKeysClient interface {
+	ListTagKeys(ctx context.Context, parent string, pageSize int64, page func(*cloudresourcemanager3.ListTagKeysResponse) error) error
+	DeleteTagKey(ctx context.Context, name string) (*operation, error)
+}
+
+type effectiveTagsClient interface {
+	ListEffectiveTags(ctx context.Context, parent string, page func(*cloudresourcemanager3.ListEffectiveTagsResponse) error) error
+}
+
+type tagValuesClient interface {
+	ListTagValues(ctx context.Context, parent string, pageSize int64, page func(*cloudresourcemanager3.ListTagValuesResponse) error) error
+	DeleteTagValue(ctx context.Context, name string) (*operation, error)
+}
+
+type sccNotificationsClient interface {
+	// ListNotificationConfigs calls config for every notification config, page after page, and
+	// returns the first listing error.
+	ListNotificationConfigs(ctx context.Context, req *securitycenterpb.ListNotificationConfigsRequest, config func(*securitycenterpb.NotificationConfig)) error
+	DeleteNotificationConfig(ctx context.Context, name string) error
+}
+
+// feedsClient lists feeds in a single response, the Cloud Asset API doesn't page them.
+type feedsClient interface {
+	ListFeeds(ctx context.Context, parent string) (*assetpb.ListFeedsResponse, error)
+	DeleteFeed(ctx context.Context, name string) error
+}
+
+type billingSinksClient interface {
+	ListBillingSinks(ctx context.Context, parent string, pageSize int64, page func(*logging.ListSinksResponse) error) error
+	DeleteBillingSink(ctx context.Context, name string) error
+}
+
+type firewallPoliciesClient interface {
+	ListFirewallPolicies(ctx context.Context, parentId string, pageSize int64, page func(*compute.FirewallPolicyList) error) error
+	RemoveFirewallPolicyAssociation(ctx context.Context, policy string, association string) (*operation, error)
+	DeleteFirewallPolicy(ctx context.Context, policy string) (*operation, error)
+}
+
+type serviceManagementClient interface {
+	ListServices(ctx context.Context, producerProjectId string, pageSize int64, page func(*servicemanagement.ListServicesResponse) error) error
+	DeleteService(ctx context.Context, serviceName string) (*operation, error)
+}
+
+type clustersClient interface {
+	ListClusters(ctx context.Context, parent string) (*containerpb.ListClustersResponse, error)
+	DeleteCluster(ctx context.Context, name string) (*operation, error)
+	// DeletionOperation returns the running deletion operation of the cluster, nil if there is none.
+	DeletionOperation(ctx context.Context, name string) (*operation, error)
+}
+
+type bucketsClient interface {
+	ListBuckets(ctx context.Context, projectId string, page func(*storage.Buckets) error) error
+}
+
+type sharedVPCClient interface {
+	// IsXpnHost reports whether the project is a Shared VPC host project.
+	IsXpnHost(ctx context.Context, projectId string) (bool, error)
+	// ListXpnResources lists the service projects attached to the Shared VPC host project.
+	ListXpnResources(ctx context.Context, projectId string, page func(*compute.ProjectsGetXpnResources) error) error
+	DisableXpnResource(ctx context.Context, hostProjectId string, serviceProjectId string) (*operation, error)
+	DisableXpnHost(ctx context.Context, projectId string) (*operation, error)
+}
+
+// clients bundles every API the cleaner talks to.
+type clients struct {
+	projects          projectsClient
+	liens             liensClient
+	folders           foldersClient
+	tagKeys           tagKeysClient
+	tagValues         tagValuesClient
+	effectiveTags     effectiveTagsClient
+	sccNotifications  sccNotificationsClient
+	feeds             feedsClient
+	billingSinks      billingSinksClient
+	firewallPolicies  firewallPoliciesClient
+	serviceManagement serviceManagementClient
+	clusters          clustersClient
+	buckets           bucketsClient
+	sharedVPC         sharedVPCClient
+	reportSinks       []reportSink
+	notifiers         []notifier
+	// stateStore keeps the checkpoint between runs, nil if checkpointing is disabled.
+	stateStore stateStore
+}
+
+type resourceManagerAdapter struct {
+	service *cloudresourcemanager.Service
+}
+
+func (a resourceManagerAdapter) ListProjects(ctx context.Context, filter string, page func(*cloudresourcemanager.ListProjectsResponse) error) error {
+	return a.service.Projects.List().Filter(filter).Pages(ctx, page)
+}
+
+func (a resourceManagerAdapter) GetProject(ctx context.Context, projectId string) (*cloudresourcemanager.Project, error) {
+	return a.service.Projects.Get(projectId).Context(ctx).Do()
+}
+
+func (a resourceManagerAdapter) DeleteProject(ctx context.Context, projectId string) error {
+	_, err := a.service.Projects.Delete(projectId).Context(ctx).Do()
+	return err
+}
+
+func (a resourceManagerAdapter) UpdateProjectLabels(ctx context.Context, projectId string, set map[string]string, remove ...string) error {
+	project, err := a.service.Projects.Get(projectId).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if project.Labels == nil {
+		project.Labels = map[string]string{}
+	}
+	for key, value := range set {
+		project.Labels[key] = value
+	}
+	for _, key := range remove {
+		delete(project.Labels, key)
+	}
+	_, err = a.service.Projects.Update(projectId, project).Context(ctx).Do()
+	return err
+}
+
+func (a resourceManagerAdapter) ListLiens(ctx context.Context, parent string, page func(*cloudresourcemanager.ListLiensResponse) error) error {
+	return a.service.Liens.List().Parent(parent).Pages(ctx, page)
+}
+
+func (a resourceManagerAdapter) DeleteLien(ctx context.Context, name string) error {
+	_, err := a.service.Liens.Delete(name).Context(ctx).Do()
+	return err
+}
+
+// foldersAdapter gets and lists folders with the v2 API, and deletes them with the v3 API which
+// returns a long-running operation.
+type foldersAdapter struct {
+	service    *cloudresourcemanager2.FoldersService
+	v3         *cloudresourcemanager3.FoldersService
+	operations *cloudresourcemanager3.OperationsService
+}
+
+func (a foldersAdapter) GetFolder(ctx context.Context, name string) (*cloudresourcemanager2.Folder, error) {
+	return a.service.Get(name).Context(ctx).Do()
+}
+
+func (a foldersAdapter) ListFolders(ctx context.Context, parent string, page func(*cloudresourcemanager2.ListFoldersResponse) error) error {
+	return a.service.List().Parent(parent).ShowDeleted(false).Pages(ctx, page)
+}
+
+func (a foldersAdapter) DeleteFolder(ctx context.Context, name string) (*operation, error) {
+	op, err := a.v3.Delete(name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resourceManagerOperation(a.operations, op), nil
+}
+
+type tagKeysAdapter struct {
+	service    *cloudresourcemanager3.TagKeysService
+	operations *cloudresourcemanager3.OperationsService
+}
+
+func (a tagKeysAdapter) ListTagKeys(ctx context.Context, parent string, pageSize int64, page func(*cloudresourcemanager3.ListTagKeysResponse) error) error {
+	return a.service.List().Parent(parent).PageSize(pageSize).Pages(ctx, page)
+}
+
+func (a tagKeysAdapter) DeleteTagKey(ctx context.Context, name string) (*operation, error) {
+	op, err := a.service.Delete(name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resourceManagerOperation(a.operations, op), nil
+}
+
+type tagValuesAdapter struct {
+	service    *cloudresourcemanager3.TagValuesService
+	operations *cloudresourcemanager3.OperationsService
+}
+
+func (a tagValuesAdapter) ListTagValues(ctx context.Context, parent string, pageSize int64, page func(*cloudresourcemanager3.ListTagValuesResponse) error) error {
+	return a.service.List().Parent(parent).PageSize(pageSize).Pages(ctx, page)
+}
+
+func (a tagValuesAdapter) DeleteTagValue(ctx context.Context, name string) (*operation, error) {
+	op, err := a.service.Delete(name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resourceManagerOperation(a.operations, op), nil
+}
+
+// resourceManagerOperation converts a Resource Manager v3 operation.
+func resourceManagerOperation(service *cloudresourcemanager3.OperationsService, op *cloudresourcemanager3.Operation) *operation {
+	result := &operation{name: op.Name, done: op.Done}
+	if op.Error != nil {
+		result.err = fmt.Errorf("operation failed with code %d, %s", op.Error.Code, op.Error.Message)
+	}
+	result.refresh = func(ctx context.Context) (*operation, error) {
+		op, err := service.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return resourceManagerOperation(service, op), nil
+	}
+	return result
+}
+
+type effectiveTagsAdapter struct {
+	service *cloudresourcemanager3.EffectiveTagsService
+}
+
+func (a effectiveTagsAdapter) ListEffectiveTags(ctx context.Context, parent string, page func(*cloudresourcemanager3.ListEffectiveTagsResponse) error) error {
+	return a.service.List().Parent(parent).Pages(ctx, page)
+}
+
+type sccNotificationsAdapter struct {
+	client *securitycenter.Client
+}
+
+func (a sccNotificationsAdapter) ListNotificationConfigs(ctx context.Context, req *securitycenterpb.ListNotificationConfigsRequest, config func(*securitycenterpb.NotificationConfig)) error {
+	it := a.client.ListNotificationConfigs(ctx, req)
+	for {
+		resp, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		config(resp)
+	}
+}
+
+func (a sccNotificationsAdapter) DeleteNotificationConfig(ctx context.Context, name string) error {
+	return a.client.DeleteNotificationConfig(ctx, &securitycenterpb.DeleteNotificationConfigRequest{Name: name})
+}
+
+type feedsAdapter struct {
+	client *asset.Client
+}
+
+func (a feedsAdapter) ListFeeds(ctx context.Context, parent string) (*assetpb.ListFeedsResponse, error) {
+	return a.client.ListFeeds(ctx, &assetpb.ListFeedsRequest{Parent: parent})
+}
+
+func (a feedsAdapter) DeleteFeed(ctx context.Context, name string) error {
+	return a.client.DeleteFeed(ctx, &assetpb.DeleteFeedRequest{Name: name})
+}
+
+type billingSinksAdapter struct {
+	service *logging.BillingAccountsSinksService
+}
+
+func (a billingSinksAdapter) ListBillingSinks(ctx context.Context, parent string, pageSize int64, page func(*logging.ListSinksResponse) error) error {
+	return a.service.List(parent).PageSize(pageSize).Pages(ctx, page)
+}
+
+func (a billingSinksAdapter) DeleteBillingSink(ctx context.Context, name string) error {
+	_, err := a.service.Delete(name).Context(ctx).Do()
+	return err
+}
+
+type firewallPoliciesAdapter struct {
+	service    *compute.FirewallPoliciesService
+	operations *compute.GlobalOrganizationOperationsService
+}
+
+func (a firewallPoliciesAdapter) ListFirewallPolicies(ctx context.Context, parentId string, pageSize int64, page func(*compute.FirewallPolicyList) error) error {
+	return a.service.List().ParentId(parentId).MaxResults(pageSize).Pages(ctx, page)
+}
+
+func (a firewallPoliciesAdapter) RemoveFirewallPolicyAssociation(ctx context.Context, policy string, association string) (*operation, error) {
+	op, err := a.service.RemoveAssociation(policy).Name(association).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return a.operation(op), nil
+}
+
+func (a firewallPoliciesAdapter) DeleteFirewallPolicy(ctx context.Context, policy string) (*operation, error) {
+	op, err := a.service.Delete(policy).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return a.operation(op), nil
+}
+
+// operation converts a Compute Engine global organization operation.
+func (a firewallPoliciesAdapter) operation(op *compute.Operation) *operation {
+	result := &operation{name: op.Name, done: op.Status == "DONE", err: computeOperationError(op)}
+	result.refresh = func(ctx context.Context) (*operation, error) {
+		op, err := a.operations.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return a.operation(op), nil
+	}
+	return result
+}
+
+// computeOperationError returns the error of the Compute Engine operation, nil if it didn't fail.
+func computeOperationError(op *compute.Operation) error {
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+	var messages []string
+	for _, e := range op.Error.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", e.Code, e.Message))
+	}
+	return fmt.Errorf("operation failed, %s", strings.Join(messages, ", "))
+}
+
+type serviceManagementAdapter struct {
+	service *servicemanagement.APIService
+}
+
+func (a serviceManagementAdapter) ListServices(ctx context.Context, producerProjectId string, pageSize int64, page func(*servicemanagement.ListServicesResponse) error) error {
+	return a.service.Services.List().ProducerProjectId(producerProjectId).PageSize(pageSize).Pages(ctx, page)
+}
+
+func (a serviceManagementAdapter) DeleteService(ctx context.Context, serviceName string) (*operation, error) {
+	op, err := a.service.Services.Delete(serviceName).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return a.operation(op), nil
+}
+
+// operation converts a Service Management operation.
+func (a serviceManagementAdapter) operation(op *servicemanagement.Operation) *operation {
+	result := &operation{name: op.Name, done: op.Done}
+	if op.Error != nil {
+		result.err = fmt.Errorf("operation failed with code %d, %s", op.Error.Code, op.Error.Message)
+	}
+	result.refresh = func(ctx context.Context) (*operation, error) {
+		op, err := a.service.Operations.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return a.operation(op), nil
+	}
+	return result
+}
+
+type clustersAdapter struct {
+	client *container.ClusterManagerClient
+}
+
+func (a clustersAdapter) ListClusters(ctx context.Context, parent string) (*containerpb.ListClustersResponse, error) {
+	return a.client.ListClusters(ctx, &containerpb.ListClustersRequest{Parent: parent})
+}
+
+func (a clustersAdapter) DeleteCluster(ctx context.Context, name string) (*operation, error) {
+	op, err := a.client.DeleteCluster(ctx, &containerpb.DeleteClusterRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	// Cluster operations are named projects/<project>/locations/<location>/operations/<name>.
+	projectId := strings.Split(name, "/")[1]
+	return a.operation(fmt.Sprintf("projects/%s/locations/%s/operations/%s", projectId, op.Location, op.Name), op), nil
+}
+
+func (a clustersAdapter) DeletionOperation(ctx context.Context, name string) (*operation, error) {
+	// Cluster names are projects/<project>/locations/<location>/clusters/<cluster>.
+	parts := strings.Split(name, "/")
+	response, err := a.client.ListOperations(ctx, &containerpb.ListOperationsRequest{Parent: strings.Join(parts[:4], "/")})
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range response.Operations {
+		if op.OperationType == containerpb.Operation_DELETE_CLUSTER && op.Status != containerpb.Operation_DONE && strings.HasSuffix(op.TargetLink, "/clusters/"+parts[5]) {
+			return a.operation(fmt.Sprintf("projects/%s/locations/%s/operations/%s", parts[1], op.Location, op.Name), op), nil
+		}
+	}
+	return nil, nil
+}
+
+// operation converts a Kubernetes Engine operation.
+func (a clustersAdapter) operation(name string, op *containerpb.Operation) *operation {
+	result := &operation{name: name, done: op.Status == containerpb.Operation_DONE}
+	if started, err := time.Parse(time.RFC3339, op.StartTime); err == nil {
+		result.started = started
+	}
+	if op.Error != nil && op.Error.Code != 0 {
+		result.err = fmt.Errorf("operation failed with code %d, %s", op.Error.Code, op.Error.Message)
+	} else if result.done && op.StatusMessage != "" {
+		result.err = fmt.Errorf("operation failed, %s", op.StatusMessage)
+	}
+	result.refresh = func(ctx context.Context) (*operation, error) {
+		op, err := a.client.GetOperation(ctx, &containerpb.GetOperationRequest{Name: name})
+		if err != nil {
+			return nil, err
+		}
+		return a.operation(name, op), nil
+	}
+	return result
+}
+
+type bucketsAdapter struct {
+	service *storage.BucketsService
+}
+
+func (a bucketsAdapter) ListBuckets(ctx context.Context, projectId string, page func(*storage.Buckets) error) error {
+	return a.service.List(projectId).Pages(ctx, page)
+}
+
+type sharedVPCAdapter struct {
+	service    *compute.ProjectsService
+	operations *compute.GlobalOperationsService
+}
+
+func (a sharedVPCAdapter) IsXpnHost(ctx context.Context, projectId string) (bool, error) {
+	project, err := a.service.Get(projectId).Fields("xpnProjectStatus").Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+	return project.XpnProjectStatus == "HOST", nil
+}
+
+func (a sharedVPCAdapter) ListXpnResources(ctx context.Context, projectId string, page func(*compute.ProjectsGetXpnResources) error) error {
+	return a.service.GetXpnResources(projectId).Pages(ctx, page)
+}
+
+func (a sharedVPCAdapter) DisableXpnResource(ctx context.Context, hostProjectId string, serviceProjectId string) (*operation, error) {
+	request := &compute.ProjectsDisableXpnResourceRequest{XpnResource: &compute.XpnResourceId{Id: serviceProjectId, Type: "PROJECT"}}
+	op, err := a.service.DisableXpnResource(hostProjectId, request).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return a.operation(hostProjectId, op), nil
+}
+
+func (a sharedVPCAdapter) DisableXpnHost(ctx context.Context, projectId string) (*operation, error) {
+	op, err := a.service.DisableXpnHost(projectId).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return a.operation(projectId, op), nil
+}
+
+// operation converts a Compute Engine global operation of the project.
+func (a sharedVPCAdapter) operation(projectId string, op *compute.Operation) *operation {
+	result := &operation{name: op.Name, done: op.Status == "DONE", err: computeOperationError(op)}
+	result.refresh = func(ctx context.Context) (*operation, error) {
+		op, err := a.operations.Get(projectId, op.Name).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		return a.operation(projectId, op), nil
+	}
+	return result
+}
@@ -0,0 +1,429 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"sync"
+
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"cloud.google.com/go/container/apiv1/containerpb"
+	"cloud.google.com/go/securitycenter/apiv1/securitycenterpb"
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	cloudresourcemanager2 "google.golang.org/api/cloudresourcemanager/v2"
+	cloudresourcemanager3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/servicemanagement/v1"
+	"google.golang.org/api/storage/v1"
+)
+
+// APIs whose concurrent calls are capped separately.
+const (
+	apiResourceManager   = "cloudresourcemanager.googleapis.com"
+	apiSecurityCenter    = "securitycenter.googleapis.com"
+	apiCloudAsset        = "cloudasset.googleapis.com"
+	apiLogging           = "logging.googleapis.com"
+	apiCompute           = "compute.googleapis.com"
+	apiServiceManagement = "servicemanagement.googleapis.com"
+	apiContainer         = "container.googleapis.com"
+	apiStorage           = "storage.googleapis.com"
+)
+
+// apiLimiter caps the number of concurrent calls made to every API.
+type apiLimiter struct {
+	mu    sync.Mutex
+	size  int
+	slots map[string]chan struct{}
+}
+
+func newAPILimiter(size int) *apiLimiter {
+	return &apiLimiter{size: max(size, 1), slots: map[string]chan struct{}{}}
+}
+
+// acquire blocks until a call to api can be made and returns the function releasing the slot.
+func (l *apiLimiter) acquire(api string) func() {
+	l.mu.Lock()
+	slots, ok := l.slots[api]
+	if !ok {
+		slots = make(chan struct{}, l.size)
+		l.slots[api] = slots
+	}
+	l.mu.Unlock()
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// apiCaller makes every API call within the concurrency limit of its API and retries it
+// according to the retry policy. The API slot is released while waiting to retry.
+type apiCaller struct {
+	limiter *apiLimiter
+	policy  retryPolicy
+}
+
+func (a *apiCaller) call(ctx context.Context, api string, call func() error) error {
+	return a.policy.do(ctx, api, func() error {
+		defer a.limiter.acquire(api)()
+		return call()
+	})
+}
+
+// operation makes a call returning a long-running operation, whose refreshes are also made
+// through the caller.
+func (a *apiCaller) operation(ctx context.Context, api string, call func() (*operation, error)) (*operation, error) {
+	var op *operation
+	err := a.call(ctx, api, func() (err error) {
+		op, err = call()
+		return err
+	})
+	if op == nil || op.refresh == nil {
+		return op, err
+	}
+	limited := *op
+	limited.refresh = func(ctx context.Context) (*operation, error) {
+		return a.operation(ctx, api, func() (*operation, error) {
+			return op.refresh(ctx)
+		})
+	}
+	return &limited, err
+}
+
+// withCaller returns clients whose calls go through the API caller. Paged list calls collect
+// every page first, so that a retry starts over and page callbacks don't hold an API slot.
+func (c clients) withCaller(a *apiCaller) clients {
+	c.projects = limitedProjectsClient{c.projects, a}
+	c.liens = limitedLiensClient{c.liens, a}
+	c.folders = limitedFoldersClient{c.folders, a}
+	c.tagKeys = limitedTagKeysClient{c.tagKeys, a}
+	c.tagValues = limitedTagValuesClient{c.tagValues, a}
+	c.effectiveTags = limitedEffectiveTagsClient{c.effectiveTags, a}
+	c.sccNotifications = limitedSCCNotificationsClient{c.sccNotifications, a}
+	c.feeds = limitedFeedsClient{c.feeds, a}
+	c.billingSinks = limitedBillingSinksClient{c.billingSinks, a}
+	c.firewallPolicies = limitedFirewallPoliciesClient{c.firewallPolicies, a}
+	c.serviceManagement = limitedServiceManagementClient{c.serviceManagement, a}
+	c.clusters = limitedClustersClient{c.clusters, a}
+	c.buckets = limitedBucketsClient{c.buckets, a}
+	c.sharedVPC = limitedSharedVPCClient{c.sharedVPC, a}
+	return c
+}
+
+// collectPages calls list, retrying it from the first page if needed, and then hands every
+// collected page to page. When listing fails for good, the pages listed before the error are
+// still handed to page, so that callers can go on with them, and the error is returned.
+func collectPages[T any](ctx context.Context, a *apiCaller, api string, list func(func(T) error) error, page func(T) error) error {
+	var pages []T
+	err := a.call(ctx, api, func() error {
+		pages = nil
+		return list(func(p T) error {
+			pages = append(pages, p)
+			return nil
+		})
+	})
+	for _, p := range pages {
+		if err := page(p); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+type limitedProjectsClient struct {
+	client projectsClient
+	a      *apiCaller
+}
+
+func (c limitedProjectsClient) ListProjects(ctx context.Context, filter string, page func(*cloudresourcemanager.ListProjectsResponse) error) error {
+	return collectPages(ctx, c.a, apiResourceManager, func(p func(*cloudresourcemanager.ListProjectsResponse) error) error {
+		return c.client.ListProjects(ctx, filter, p)
+	}, page)
+}
+
+func (c limitedProjectsClient) GetProject(ctx context.Context, projectId string) (*cloudresourcemanager.Project, error) {
+	var project *cloudresourcemanager.Project
+	err := c.a.call(ctx, apiResourceManager, func() (err error) {
+		project, err = c.client.GetProject(ctx, projectId)
+		return err
+	})
+	return project, err
+}
+
+func (c limitedProjectsClient) DeleteProject(ctx context.Context, projectId string) error {
+	return c.a.call(ctx, apiResourceManager, func() error {
+		return c.client.DeleteProject(ctx, projectId)
+	})
+}
+
+func (c limitedProjectsClient) UpdateProjectLabels(ctx context.Context, projectId string, set map[string]string, remove ...string) error {
+	return c.a.call(ctx, apiResourceManager, func() error {
+		return c.client.UpdateProjectLabels(ctx, projectId, set, remove...)
+	})
+}
+
+type limitedLiensClient struct {
+	client liensClient
+	a      *apiCaller
+}
+
+func (c limitedLiensClient) ListLiens(ctx context.Context, parent string, page func(*cloudresourcemanager.ListLiensResponse) error) error {
+	return collectPages(ctx, c.a, apiResourceManager, func(p func(*cloudresourcemanager.ListLiensResponse) error) error {
+		return c.client.ListLiens(ctx, parent, p)
+	}, page)
+}
+
+func (c limitedLiensClient) DeleteLien(ctx context.Context, name string) error {
+	return c.a.call(ctx, apiResourceManager, func() error {
+		return c.client.DeleteLien(ctx, name)
+	})
+}
+
+type limitedFoldersClient struct {
+	client foldersClient
+	a      *apiCaller
+}
+
+func (c limitedFoldersClient) GetFolder(ctx context.Context, name string) (*cloudresourcemanager2.Folder, error) {
+	var folder *cloudresourcemanager2.Folder
+	err := c.a.call(ctx, apiResourceManager, func() (err error) {
+		folder, err = c.client.GetFolder(ctx, name)
+		return err
+	})
+	return folder, err
+}
+
+func (c limitedFoldersClient) ListFolders(ctx context.Context, parent string, page func(*cloudresourcemanager2.ListFoldersResponse) error) error {
+	return collectPages(ctx, c.a, apiResourceManager, func(p func(*cloudresourcemanager2.ListFoldersResponse) error) error {
+		return c.client.ListFolders(ctx, parent, p)
+	}, page)
+}
+
+func (c limitedFoldersClient) DeleteFolder(ctx context.Context, name string) (*operation, error) {
+	return c.a.operation(ctx, apiResourceManager, func() (*operation, error) {
+		return c.client.DeleteFolder(ctx, name)
+	})
+}
+
+type limitedTagKeysClient struct {
+	client tagKeysClient
+	a      *apiCaller
+}
+
+func (c limitedTagKeysClient) ListTagKeys(ctx context.Context, parent string, pageSize int64, page func(*cloudresourcemanager3.ListTagKeysResponse) error) error {
+	return collectPages(ctx, c.a, apiResourceManager, func(p func(*cloudresourcemanager3.ListTagKeysResponse) error) error {
+		return c.client.ListTagKeys(ctx, parent, pageSize, p)
+	}, page)
+}
+
+func (c limitedTagKeysClient) DeleteTagKey(ctx context.Context, name string) (*operation, error) {
+	return c.a.operation(ctx, apiResourceManager, func() (*operation, error) {
+		return c.client.DeleteTagKey(ctx, name)
+	})
+}
+
+type limitedTagValuesClient struct {
+	client tagValuesClient
+	a      *apiCaller
+}
+
+func (c limitedTagValuesClient) ListTagValues(ctx context.Context, parent string, pageSize int64, page func(*cloudresourcemanager3.ListTagValuesResponse) error) error {
+	return collectPages(ctx, c.a, apiResourceManager, func(p func(*cloudresourcemanager3.ListTagValuesResponse) error) error {
+		return c.client.ListTagValues(ctx, parent, pageSize, p)
+	}, page)
+}
+
+func (c limitedTagValuesClient) DeleteTagValue(ctx context.Context, name string) (*operation, error) {
+	return c.a.operation(ctx, apiResourceManager, func() (*operation, error) {
+		return c.client.DeleteTagValue(ctx, name)
+	})
+}
+
+type limitedEffectiveTagsClient struct {
+	client effectiveTagsClient
+	a      *apiCaller
+}
+
+func (c limitedEffectiveTagsClient) ListEffectiveTags(ctx context.Context, parent string, page func(*cloudresourcemanager3.ListEffectiveTagsResponse) error) error {
+	return collectPages(ctx, c.a, apiResourceManager, func(p func(*cloudresourcemanager3.ListEffectiveTagsResponse) error) error {
+		return c.client.ListEffectiveTags(ctx, parent, p)
+	}, page)
+}
+
+type limitedSCCNotificationsClient struct {
+	client sccNotificationsClient
+	a      *apiCaller
+}
+
+func (c limitedSCCNotificationsClient) ListNotificationConfigs(ctx context.Context, req *securitycenterpb.ListNotificationConfigsRequest, config func(*securitycenterpb.NotificationConfig)) error {
+	return collectPages(ctx, c.a, apiSecurityCenter, func(p func(*securitycenterpb.NotificationConfig) error) error {
+		return c.client.ListNotificationConfigs(ctx, req, func(n *securitycenterpb.NotificationConfig) { _ = p(n) })
+	}, func(n *securitycenterpb.NotificationConfig) error {
+		config(n)
+		return nil
+	})
+}
+
+func (c limitedSCCNotificationsClient) DeleteNotificationConfig(ctx context.Context, name string) error {
+	return c.a.call(ctx, apiSecurityCenter, func() error {
+		return c.client.DeleteNotificationConfig(ctx, name)
+	})
+}
+
+type limitedFeedsClient struct {
+	client feedsClient
+	a      *apiCaller
+}
+
+func (c limitedFeedsClient) ListFeeds(ctx context.Context, parent string) (*assetpb.ListFeedsResponse, error) {
+	var response *assetpb.ListFeedsResponse
+	err := c.a.call(ctx, apiCloudAsset, func() (err error) {
+		response, err = c.client.ListFeeds(ctx, parent)
+		return err
+	})
+	return response, err
+}
+
+func (c limitedFeedsClient) DeleteFeed(ctx context.Context, name string) error {
+	return c.a.call(ctx, apiCloudAsset, func() error {
+		return c.client.DeleteFeed(ctx, name)
+	})
+}
+
+type limitedBillingSinksClient struct {
+	client billingSinksClient
+	a      *apiCaller
+}
+
+func (c limitedBillingSinksClient) ListBillingSinks(ctx context.Context, parent string, pageSize int64, page func(*logging.ListSinksResponse) error) error {
+	return collectPages(ctx, c.a, apiLogging, func(p func(*logging.ListSinksResponse) error) error {
+		return c.client.ListBillingSinks(ctx, parent, pageSize, p)
+	}, page)
+}
+
+func (c limitedBillingSinksClient) DeleteBillingSink(ctx context.Context, name string) error {
+	return c.a.call(ctx, apiLogging, func() error {
+		return c.client.DeleteBillingSink(ctx, name)
+	})
+}
+
+type limitedFirewallPoliciesClient struct {
+	client firewallPoliciesClient
+	a      *apiCaller
+}
+
+func (c limitedFirewallPoliciesClient) ListFirewallPolicies(ctx context.Context, parentId string, pageSize int64, page func(*compute.FirewallPolicyList) error) error {
+	return collectPages(ctx, c.a, apiCompute, func(p func(*compute.FirewallPolicyList) error) error {
+		return c.client.ListFirewallPolicies(ctx, parentId, pageSize, p)
+	}, page)
+}
+
+func (c limitedFirewallPoliciesClient) RemoveFirewallPolicyAssociation(ctx context.Context, policy string, association string) (*operation, error) {
+	return c.a.operation(ctx, apiCompute, func() (*operation, error) {
+		return c.client.RemoveFirewallPolicyAssociation(ctx, policy, association)
+	})
+}
+
+func (c limitedFirewallPoliciesClient) DeleteFirewallPolicy(ctx context.Context, policy string) (*operation, error) {
+	return c.a.operation(ctx, apiCompute, func() (*operation, error) {
+		return c.client.DeleteFirewallPolicy(ctx, policy)
+	})
+}
+
+type limitedServiceManagementClient struct {
+	client serviceManagementClient
+	a      *apiCaller
+}
+
+func (c limitedServiceManagementClient) ListServices(ctx context.Context, producerProjectId string, pageSize int64, page func(*servicemanagement.ListServicesResponse) error) error {
+	return collectPages(ctx, c.a, apiServiceManagement, func(p func(*servicemanagement.ListServicesResponse) error) error {
+		return c.client.ListServices(ctx, producerProjectId, pageSize, p)
+	}, page)
+}
+
+func (c limitedServiceManagementClient) DeleteService(ctx context.Context, serviceName string) (*operation, error) {
+	return c.a.operation(ctx, apiServiceManagement, func() (*operation, error) {
+		return c.client.DeleteService(ctx, serviceName)
+	})
+}
+
+type limitedClustersClient struct {
+	client clustersClient
+	a      *apiCaller
+}
+
+func (c limitedClustersClient) ListClusters(ctx context.Context, parent string) (*containerpb.ListClustersResponse, error) {
+	var response *containerpb.ListClustersResponse
+	err := c.a.call(ctx, apiContainer, func() (err error) {
+		response, err = c.client.ListClusters(ctx, parent)
+		return err
+	})
+	return response, err
+}
+
+func (c limitedClustersClient) DeleteCluster(ctx context.Context, name string) (*operation, error) {
+	return c.a.operation(ctx, apiContainer, func() (*operation, error) {
+		return c.client.DeleteCluster(ctx, name)
+	})
+}
+
+func (c limitedClustersClient) DeletionOperation(ctx context.Context, name string) (*operation, error) {
+	return c.a.operation(ctx, apiContainer, func() (*operation, error) {
+		return c.client.DeletionOperation(ctx, name)
+	})
+}
+
+type limitedBucketsClient struct {
+	client bucketsClient
+	a      *apiCaller
+}
+
+func (c limitedBucketsClient) ListBuckets(ctx context.Context, projectId string, page func(*storage.Buckets) error) error {
+	return collectPages(ctx, c.a, apiStorage, func(p func(*storage.Buckets) error) error {
+		return c.client.ListBuckets(ctx, projectId, p)
+	}, page)
+}
+
+type limitedSharedVPCClient struct {
+	client sharedVPCClient
+	a      *apiCaller
+}
+
+func (c limitedSharedVPCClient) IsXpnHost(ctx context.Context, projectId string) (bool, error) {
+	var host bool
+	err := c.a.call(ctx, apiCompute, func() (err error) {
+		host, err = c.client.IsXpnHost(ctx, projectId)
+		return err
+	})
+	return host, err
+}
+
+func (c limitedSharedVPCClient) ListXpnResources(ctx context.Context, projectId string, page func(*compute.ProjectsGetXpnResources) error) error {
+	return collectPages(ctx, c.a, apiCompute, func(p func(*compute.ProjectsGetXpnResources) error) error {
+		return c.client.ListXpnResources(ctx, projectId, p)
+	}, page)
+}
+
+func (c limitedSharedVPCClient) DisableXpnResource(ctx context.Context, hostProjectId string, serviceProjectId string) (*operation, error) {
+	return c.a.operation(ctx, apiCompute, func() (*operation, error) {
+		return c.client.DisableXpnResource(ctx, hostProjectId, serviceProjectId)
+	})
+}
+
+func (c limitedSharedVPCClient) DisableXpnHost(ctx context.Context, projectId string) (*operation, error) {
+	return c.a.operation(ctx, apiCompute, func() (*operation, error) {
+		return c.client.DisableXpnHost(ctx, projectId)
+	})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+func TestAPILimiterCapsConcurrentCalls(t *testing.T) {
+	l := newAPILimiter(2)
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer l.acquire(apiResourceManager)()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	if maxRunning > 2 {
+		t.Errorf("got %d concurrent calls, want at most 2", maxRunning)
+	}
+	// Other APIs have their own slots.
+	release := l.acquire(apiResourceManager)
+	defer release()
+	l.acquire(apiContainer)()
+}
+
+func TestRunCleansUpProjectsConcurrently(t *testing.T) {
+	f := newTestHierarchy()
+	for i := 0; i < 20; i++ {
+		projectId := fmt.Sprintf("old-300-%d", i)
+		f.addProject(projectId, "300", oldTime, nil)
+		f.liens["projects/"+projectId] = []*cloudresourcemanager.Lien{{Name: "liens/" + projectId, Parent: "projects/" + projectId}}
+	}
+	f.latency = time.Millisecond
+	config := testConfig()
+	config.ProjectParallelism = 8
+	config.MaxConcurrentAPICalls = 3
+
+	report := newTestCleaner(f, config).run(context.Background())
+
+	// Every project lists its buckets exactly once, overlapping calls mean overlapping projects.
+	if f.peakInFlight[apiStorage] < 2 {
+		t.Errorf("got at most %d projects cleaned up at the same time, want them to run in parallel", f.peakInFlight[apiStorage])
+	}
+	for api, peak := range f.peakInFlight {
+		if peak > config.MaxConcurrentAPICalls {
+			t.Errorf("got %d concurrent calls to %s, want at most %d", peak, api, config.MaxConcurrentAPICalls)
+		}
+	}
+
+	if got := report.resource(resourceProject).DeletedCount; got != 22 {
+		t.Errorf("got %d deleted projects, want 22, report %s", got, report.summary())
+	}
+	if got := report.resource(resourceLien).DeletedCount; got != 20 {
+		t.Errorf("got %d deleted liens, want 20", got)
+	}
+	if !f.called("DeleteFolder", "folders/300") {
+		t.Errorf("folder was not deleted after its projects, calls %v", f.calls)
+	}
+}
@@ -0,0 +1,327 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Config holds the cleaner configuration, see LoadConfigFromEnv.
+type Config struct {
+	OrganizationId              string
+	RootFolderId                string
+	TargetFolders               []TargetFolder
+	CleanUpOrganizationProjects bool
+	MaxProjectAgeHours          int64
+	IncludedLabels              map[string]string
+	ExcludedLabels              map[string]string
+	IncludedLabelSelector       LabelSelector
+	ExcludedLabelSelector       LabelSelector
+	IncludedTags                []string
+	ExcludedTags                []string
+	IncludedProjects            []*regexp.Regexp
+	ExcludedProjects            []*regexp.Regexp
+	IncludedFolders             []*regexp.Regexp
+	ExcludedFolders             []*regexp.Regexp
+	ExcludedFolderTags          []string
+	FolderDeletionMode          string
+	ProtectedFolderDepth        int
+	PreservedLiens              []*regexp.Regexp
+	RemovableLienOrigins        []*regexp.Regexp
+	DeletionGracePeriodHours    int64
+	SlackWebhookURL             string
+	GoogleChatWebhookURL        string
+	WebhookURL                  string
+	SMTPAddress                 string
+	SMTPFrom                    string
+	SMTPTo                      []string
+	SMTPUsername                string
+	SMTPPassword                string
+	CleanUpTagKeys              bool
+	ExcludedTagKeys             []string
+	CleanUpSCCNotifications     bool
+	IncludedSCCNotifications    []*regexp.Regexp
+	SCCPageSize                 int32
+	CleanUpCaiFeeds             bool
+	IncludedFeeds               []*regexp.Regexp
+	BillingAccount              string
+	CleanUpBillingSinks         bool
+	BillingSinksPageSize        int64
+	TagKeysPageSize             int64
+	TagValuesPageSize           int64
+	FirewallPoliciesPageSize    int64
+	EndpointsServicesPageSize   int64
+	TargetBillingSinks          []*regexp.Regexp
+	DryRun                      bool
+	ReportBucket                string
+	ReportPrefix                string
+	ReportTopic                 string
+	ReturnErrorOnFailure        bool
+	ProjectParallelism          int
+	MaxConcurrentAPICalls       int
+	RetryMaxAttempts            int
+	RetryInitialDelay           time.Duration
+	RetryMaxElapsed             time.Duration
+	OperationTimeout            time.Duration
+	OperationPollInterval       time.Duration
+	ClusterDeletionTimeout      time.Duration
+	ClusterStoppingAlert        time.Duration
+	RunTimeout                  time.Duration
+	DeadlineMargin              time.Duration
+	CheckpointBucket            string
+	CheckpointObject            string
+	HostProjectId               string
+	ProtectedResources          []string
+}
+
+// LoadConfigFromEnv reads and validates the configuration from the environment variables.
+// It returns every validation problem at once rather than stopping at the first one.
+func LoadConfigFromEnv() (Config, error) {
+	l := &envLoader{lookup: os.LookupEnv}
+	config := Config{
+		OrganizationId:              l.matching(TargetOrganizationId, targetOrganizationRegexp, true),
+		RootFolderId:                l.string(TargetFolderId),
+		TargetFolders:               l.targetFolders(TargetFolders),
+		CleanUpOrganizationProjects: l.optionalBool(CleanUpOrganizationProjects),
+		MaxProjectAgeHours:          l.int(MaxProjectAgeHours, 0),
+		IncludedLabels:              l.labels(TargetIncludedLabels),
+		ExcludedLabels:              l.labels(TargetExcludedLabels),
+		IncludedLabelSelector:       l.labelSelector(TargetIncludedLabelSelector),
+		ExcludedLabelSelector:       l.labelSelector(TargetExcludedLabelSelector),
+		IncludedTags:                l.tagList(TargetIncludedTags),
+		ExcludedTags:                l.tagList(TargetExcludedTags),
+		IncludedProjects:            l.regexList(TargetIncludedProjects),
+		ExcludedProjects:            l.regexList(TargetExcludedProjects),
+		IncludedFolders:             l.regexList(TargetIncludedFolders),
+		ExcludedFolders:             l.regexList(TargetExcludedFolders),
+		ExcludedFolderTags:          l.tagList(TargetExcludedFolderTags),
+		FolderDeletionMode:          l.matching(FolderDeletionMode, folderDeletionModeRegexp, false),
+		ProtectedFolderDepth:        int(l.optionalInt(ProtectedFolderDepth, 1, 0)),
+		PreservedLiens:              l.regexList(PreservedLiens),
+		RemovableLienOrigins:        l.regexList(RemovableLienOrigins),
+		DeletionGracePeriodHours:    l.optionalInt(DeletionGracePeriodHours, 0, 0),
+		SlackWebhookURL:             l.matching(NotifySlackWebhookURL, webhookURLRegexp, false),
+		GoogleChatWebhookURL:        l.matching(NotifyGoogleChatWebhookURL, webhookURLRegexp, false),
+		WebhookURL:                  l.matching(NotifyWebhookURL, webhookURLRegexp, false),
+		SMTPAddress:                 l.matching(NotifySMTPAddress, smtpAddressRegexp, false),
+		SMTPFrom:                    l.string(NotifySMTPFrom),
+		SMTPTo:                      l.stringList(NotifySMTPTo),
+		SMTPUsername:                l.string(NotifySMTPUsername),
+		SMTPPassword:                l.string(NotifySMTPPassword),
+		CleanUpTagKeys:              l.bool(CleanUpTagKeys),
+		ExcludedTagKeys:             l.stringList(TargetExcludedTagKeys),
+		CleanUpSCCNotifications:     l.bool(CleanUpSCCNotfi),
+		IncludedSCCNotifications:    l.regexList(TargetIncludedSCCNotfis),
+		SCCPageSize:                 int32(l.int(SCCNotificationsPageSize, 1, 1000)),
+		CleanUpCaiFeeds:             l.bool(CleanUpCaiFeeds),
+		IncludedFeeds:               l.regexList(TargetIncludedFeeds),
+		BillingAccount:              l.matching(BillingAccount, billingAccountRegex, false),
+		CleanUpBillingSinks:         l.bool(CleanUpBillingSinks),
+		BillingSinksPageSize:        l.int(BillingSinksPageSize, 1),
+		TagKeysPageSize:             l.optionalInt(TagKeysPageSize, 300, 1, 300),
+		TagValuesPageSize:           l.optionalInt(TagValuesPageSize, 300, 1, 300),
+		FirewallPoliciesPageSize:    l.optionalInt(FirewallPoliciesPageSize, 500, 1, 500),
+		EndpointsServicesPageSize:   l.optionalInt(EndpointsServicesPageSize, 100, 1, 500),
+		TargetBillingSinks:          l.regexList(TargetBillingSinks),
+		DryRun:                      l.optionalBool(DryRun),
+		ReportBucket:                l.string(ReportGCSBucket),
+		ReportPrefix:                l.string(ReportGCSPrefix),
+		ReportTopic:                 l.matching(ReportPubSubTopic, pubSubTopicRegexp, false),
+		ReturnErrorOnFailure:        l.optionalBool(ReturnErrorOnFailure),
+		ProjectParallelism:          int(l.optionalInt(ProjectParallelism, 10, 1)),
+		MaxConcurrentAPICalls:       int(l.optionalInt(MaxConcurrentAPICalls, 10, 1)),
+		RetryMaxAttempts:            int(l.optionalInt(RetryMaxAttempts, 5, 1)),
+		RetryInitialDelay:           time.Duration(l.optionalInt(RetryInitialDelayMs, 1000, 1)) * time.Millisecond,
+		RetryMaxElapsed:             time.Duration(l.optionalInt(RetryMaxElapsedSeconds, 300, 1)) * time.Second,
+		OperationTimeout:            time.Duration(l.optionalInt(OperationTimeoutSeconds, 120, 0)) * time.Second,
+		OperationPollInterval:       time.Duration(l.optionalInt(OperationPollIntervalSeconds, 5, 1)) * time.Second,
+		ClusterDeletionTimeout:      time.Duration(l.optionalInt(ClusterDeletionTimeoutSeconds, 120, 0)) * time.Second,
+		ClusterStoppingAlert:        time.Duration(l.optionalInt(ClusterStoppingAlertHours, 24, 0)) * time.Hour,
+		RunTimeout:                  time.Duration(l.optionalInt(FunctionTimeoutSeconds, 0, 0)) * time.Second,
+		DeadlineMargin:              time.Duration(l.optionalInt(DeadlineMarginSeconds, 60, 0)) * time.Second,
+		CheckpointBucket:            l.string(CheckpointGCSBucket),
+		CheckpointObject:            l.string(CheckpointGCSObject),
+		HostProjectId:               l.string(HostProjectId),
+		ProtectedResources:          l.protectedResources(ProtectedResources),
+	}
+	if config.FolderDeletionMode == "" {
+		config.FolderDeletionMode = folderDeletionAlways
+	}
+	if config.CheckpointObject == "" {
+		config.CheckpointObject = "checkpoint.json"
+	}
+	l.errs = append(l.errs, config.validateTargets(TargetFolderId, TargetFolders, CleanUpOrganizationProjects)...)
+	if config.CleanUpBillingSinks && config.BillingAccount == "" {
+		l.errorf("[%s] must be set when [%s] is enabled", BillingAccount, CleanUpBillingSinks)
+	}
+	if config.SMTPAddress != "" && (config.SMTPFrom == "" || len(config.SMTPTo) == 0) {
+		l.errorf("[%s] and [%s] must be set when [%s] is set", NotifySMTPFrom, NotifySMTPTo, NotifySMTPAddress)
+	}
+	if len(l.errs) > 0 {
+		return Config{}, fmt.Errorf("invalid configuration: %w", errors.Join(l.errs...))
+	}
+	return config, nil
+}
+
+// envLoader parses environment variables and collects every parsing error.
+type envLoader struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (l *envLoader) errorf(format string, v ...interface{}) {
+	l.errs = append(l.errs, fmt.Errorf(format, v...))
+}
+
+func (l *envLoader) string(name string) string {
+	value, _ := l.lookup(name)
+	return value
+}
+
+func (l *envLoader) bool(name string) bool {
+	value, exists := l.lookup(name)
+	if !exists {
+		l.errorf("environment variable [%s] not set", name)
+		return false
+	}
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		l.errorf("invalid bool value [%s] for [%s]", value, name)
+	}
+	return result
+}
+
+func (l *envLoader) optionalBool(name string) bool {
+	if value := l.string(name); value == "" {
+		return false
+	}
+	return l.bool(name)
+}
+
+// int parses a required integer, bounds holds the optional minimum and maximum values.
+func (l *envLoader) int(name string, bounds ...int64) int64 {
+	value := l.string(name)
+	result, err := strconv.ParseInt(value, 10, 0)
+	if err != nil {
+		l.errorf("could not convert [%s] to integer for [%s]", value, name)
+		return 0
+	}
+	if len(bounds) > 0 && result < bounds[0] {
+		l.errorf("[%s] must be at least %d, got %d", name, bounds[0], result)
+	}
+	if len(bounds) > 1 && result > bounds[1] {
+		l.errorf("[%s] must be at most %d, got %d", name, bounds[1], result)
+	}
+	return result
+}
+
+// optionalInt parses an optional integer, returning defaultValue if the variable is not set.
+func (l *envLoader) optionalInt(name string, defaultValue int64, bounds ...int64) int64 {
+	if value := l.string(name); value == "" {
+		return defaultValue
+	}
+	return l.int(name, bounds...)
+}
+
+func (l *envLoader) matching(name string, pattern string, required bool) string {
+	value := l.string(name)
+	if value == "" && !required {
+		return value
+	}
+	if !regexp.MustCompile(pattern).MatchString(value) {
+		l.errorf("invalid value [%s] for [%s], it must match [%s]", value, name, pattern)
+	}
+	return value
+}
+
+// json decodes a JSON encoded variable into result, empty variables are left untouched.
+func (l *envLoader) json(name string, result interface{}) {
+	value := l.string(name)
+	if value == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(value), result); err != nil {
+		l.errorf("failed to parse [%s] from JSON, error [%s]", name, err.Error())
+	}
+}
+
+func (l *envLoader) targetFolders(name string) []TargetFolder {
+	var targets []TargetFolder
+	l.json(name, &targets)
+	return targets
+}
+
+func (l *envLoader) labels(name string) map[string]string {
+	var labels map[string]string
+	l.json(name, &labels)
+	return labels
+}
+
+func (l *envLoader) labelSelector(name string) LabelSelector {
+	selector, err := ParseLabelSelector(l.string(name))
+	if err != nil {
+		l.errorf("invalid value for [%s], %s", name, err.Error())
+	}
+	return selector
+}
+
+func (l *envLoader) stringList(name string) []string {
+	var list []string
+	l.json(name, &list)
+	return list
+}
+
+// tagList parses a list of namespaced tag keys or values, e.g. ["123/env", "123/env/ci"].
+func (l *envLoader) tagList(name string) []string {
+	tags := l.stringList(name)
+	for _, tag := range tags {
+		if !regexp.MustCompile(namespacedTagRegexp).MatchString(tag) {
+			l.errorf("invalid tag [%s] for [%s], it must match [%s]", tag, name, namespacedTagRegexp)
+		}
+	}
+	return tags
+}
+
+// protectedResources parses a list of resource names, e.g. ["projects/shared-vpc", "folders/123"].
+func (l *envLoader) protectedResources(name string) []string {
+	resources := l.stringList(name)
+	for _, resource := range resources {
+		if !regexp.MustCompile(protectedResourceRegexp).MatchString(resource) {
+			l.errorf("invalid resource [%s] for [%s], it must match [%s]", resource, name, protectedResourceRegexp)
+		}
+	}
+	return resources
+}
+
+func (l *envLoader) regexList(name string) []*regexp.Regexp {
+	var compiledRegEx []*regexp.Regexp
+	for _, r := range l.stringList(name) {
+		result, err := regexp.Compile(r)
+		if err != nil {
+			l.errorf("invalid regular expression [%s] for [%s], error [%s]", r, name, err.Error())
+			continue
+		}
+		compiledRegEx = append(compiledRegEx, result)
+	}
+	return compiledRegEx
+}
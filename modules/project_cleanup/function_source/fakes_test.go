@@ -0,0 +1,620 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"cloud.google.com/go/container/apiv1/containerpb"
+	"cloud.google.com/go/securitycenter/apiv1/securitycenterpb"
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	cloudresourcemanager2 "google.golang.org/api/cloudresourcemanager/v2"
+	cloudresourcemanager3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/servicemanagement/v1"
+	"google.golang.org/api/storage/v1"
+)
+
+// fakeCloud is an in-memory implementation of every client interface used by the cleaner.
+// Mutating calls are recorded in calls, in order, as "<Method> <resource name>".
+type fakeCloud struct {
+	mu                  sync.Mutex
+	projects            map[string]*cloudresourcemanager.Project
+	liens               map[string][]*cloudresourcemanager.Lien
+	folders             map[string]*cloudresourcemanager2.Folder
+	tagKeys             []*cloudresourcemanager3.TagKey
+	tagValues           map[string][]*cloudresourcemanager3.TagValue
+	effectiveTags       map[string][]*cloudresourcemanager3.EffectiveTag
+	notificationConfigs []*securitycenterpb.NotificationConfig
+	feeds               []*assetpb.Feed
+	billingSinks        []*logging.LogSink
+	firewallPolicies    map[string][]*compute.FirewallPolicy
+	services            map[string][]*servicemanagement.ManagedService
+	clusters            map[string][]*containerpb.Cluster
+	buckets             map[string][]*storage.Bucket
+	// xpnResources holds the service projects of every Shared VPC host project.
+	xpnResources map[string][]*compute.XpnResourceId
+	// failures makes the call with the matching "<Method> <resource name>" key fail.
+	failures map[string]error
+	// operations makes the call with the matching key return an operation which is done
+	// after that many polls, -1 keeps it running. operationErrors makes it fail when done.
+	operations      map[string]int
+	operationErrors map[string]error
+	// operationStarts sets when the operation of the call with the matching key started.
+	operationStarts map[string]time.Time
+	// unavailable makes the call with the matching key fail that many times with a 503.
+	unavailable map[string]int
+	calls       []string
+	reportSinks []reportSink
+	notifiers   []notifier
+	// latency delays the calls made to the per project APIs, so that concurrent calls overlap.
+	// inFlight and peakInFlight count these calls per API.
+	latency      time.Duration
+	tracking     sync.Mutex
+	inFlight     map[string]int
+	peakInFlight map[string]int
+}
+
+func newFakeCloud() *fakeCloud {
+	return &fakeCloud{
+		projects:         map[string]*cloudresourcemanager.Project{},
+		liens:            map[string][]*cloudresourcemanager.Lien{},
+		folders:          map[string]*cloudresourcemanager2.Folder{},
+		tagValues:        map[string][]*cloudresourcemanager3.TagValue{},
+		effectiveTags:    map[string][]*cloudresourcemanager3.EffectiveTag{},
+		firewallPolicies: map[string][]*compute.FirewallPolicy{},
+		services:         map[string][]*servicemanagement.ManagedService{},
+		clusters:         map[string][]*containerpb.Cluster{},
+		buckets:          map[string][]*storage.Bucket{},
+		xpnResources:     map[string][]*compute.XpnResourceId{},
+		failures:         map[string]error{},
+		unavailable:      map[string]int{},
+		operations:       map[string]int{},
+		operationErrors:  map[string]error{},
+		operationStarts:  map[string]time.Time{},
+		inFlight:         map[string]int{},
+		peakInFlight:     map[string]int{},
+	}
+}
+
+func (f *fakeCloud) clients() clients {
+	return clients{
+		projects:          f,
+		liens:             f,
+		folders:           f,
+		tagKeys:           f,
+		tagValues:         f,
+		effectiveTags:     f,
+		sccNotifications:  f,
+		feeds:             f,
+		billingSinks:      f,
+		firewallPolicies:  f,
+		serviceManagement: f,
+		clusters:          f,
+		buckets:           f,
+		sharedVPC:         f,
+		reportSinks:       f.reportSinks,
+		notifiers:         f.notifiers,
+	}
+}
+
+func (f *fakeCloud) addFolder(id string, parent string, createTime string) *cloudresourcemanager2.Folder {
+	folder := &cloudresourcemanager2.Folder{
+		Name:           "folders/" + id,
+		Parent:         parent,
+		CreateTime:     createTime,
+		LifecycleState: "ACTIVE",
+	}
+	f.folders[folder.Name] = folder
+	return folder
+}
+
+func (f *fakeCloud) addProject(projectId string, folderId string, createTime string, labels map[string]string) *cloudresourcemanager.Project {
+	project := &cloudresourcemanager.Project{
+		ProjectId:      projectId,
+		Name:           projectId,
+		CreateTime:     createTime,
+		Labels:         labels,
+		LifecycleState: "ACTIVE",
+		Parent:         &cloudresourcemanager.ResourceId{Type: "folder", Id: folderId},
+	}
+	f.projects[projectId] = project
+	return project
+}
+
+func (f *fakeCloud) record(method string, name string) error {
+	call := fmt.Sprintf("%s %s", method, name)
+	f.calls = append(f.calls, call)
+	if f.unavailable[call] > 0 {
+		f.unavailable[call]--
+		return &googleapi.Error{Code: 503, Message: "service unavailable"}
+	}
+	return f.failures[call]
+}
+
+// operation returns the operation configured for the call, nil if it completes synchronously.
+func (f *fakeCloud) operation(method string, name string) *operation {
+	call := fmt.Sprintf("%s %s", method, name)
+	polls, ok := f.operations[call]
+	if !ok {
+		return nil
+	}
+	op := &operation{name: "operations/" + call, done: polls == 0, started: f.operationStarts[call]}
+	if op.done {
+		op.err = f.operationErrors[call]
+	}
+	op.refresh = func(ctx context.Context) (*operation, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.calls = append(f.calls, "GetOperation "+call)
+		if f.operations[call] > 0 {
+			f.operations[call]--
+		}
+		return f.operation(method, name), nil
+	}
+	return op
+}
+
+// track records a call to api as in flight until the returned function is called. It must be
+// called before locking f.mu, so that concurrent calls overlap.
+func (f *fakeCloud) track(api string) func() {
+	f.tracking.Lock()
+	f.inFlight[api]++
+	f.peakInFlight[api] = max(f.peakInFlight[api], f.inFlight[api])
+	f.tracking.Unlock()
+	time.Sleep(f.latency)
+	return func() {
+		f.tracking.Lock()
+		defer f.tracking.Unlock()
+		f.inFlight[api]--
+	}
+}
+
+func (f *fakeCloud) called(method string, name string) bool {
+	call := fmt.Sprintf("%s %s", method, name)
+	for _, c := range f.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+// fakePages hands the items to page in pages of pageSize, all of them if pageSize is 0. A
+// failure is returned after the first page, as if listing the next page failed.
+func fakePages[T any](items []T, pageSize int64, failure error, page func([]T) error) error {
+	size := len(items) + 1
+	if pageSize > 0 {
+		size = int(pageSize)
+	}
+	for i := 0; i == 0 || i < len(items); i += size {
+		if i > 0 && failure != nil {
+			return failure
+		}
+		if err := page(items[i:min(i+size, len(items))]); err != nil {
+			return err
+		}
+	}
+	return failure
+}
+
+func notFound(name string) error {
+	return &googleapi.Error{Code: 404, Message: fmt.Sprintf("%s not found", name)}
+}
+
+func (f *fakeCloud) ListProjects(ctx context.Context, filter string, page func(*cloudresourcemanager.ListProjectsResponse) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parentType, parentId := "", ""
+	for _, term := range strings.Fields(filter) {
+		if strings.HasPrefix(term, "parent.type:") {
+			parentType = strings.TrimPrefix(term, "parent.type:")
+		}
+		if strings.HasPrefix(term, "parent.id:") {
+			parentId = strings.TrimPrefix(term, "parent.id:")
+		}
+	}
+	response := &cloudresourcemanager.ListProjectsResponse{}
+	for _, project := range f.projects {
+		if project.Parent != nil && project.Parent.Type == parentType && project.Parent.Id == parentId {
+			response.Projects = append(response.Projects, project)
+		}
+	}
+	return page(response)
+}
+
+func (f *fakeCloud) GetProject(ctx context.Context, projectId string) (*cloudresourcemanager.Project, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	project, ok := f.projects[projectId]
+	if !ok {
+		return nil, notFound(projectId)
+	}
+	return project, nil
+}
+
+func (f *fakeCloud) DeleteProject(ctx context.Context, projectId string) error {
+	defer f.track(apiResourceManager)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteProject", projectId); err != nil {
+		return err
+	}
+	project, ok := f.projects[projectId]
+	if !ok {
+		return notFound(projectId)
+	}
+	if len(f.liens["projects/"+projectId]) > 0 {
+		return &googleapi.Error{Code: 412, Message: "project has liens"}
+	}
+	if len(f.services[projectId]) > 0 {
+		return &googleapi.Error{Code: 400, Message: "project has active Endpoints services"}
+	}
+	if _, ok := f.xpnResources[projectId]; ok {
+		return &googleapi.Error{Code: 400, Message: "project is a Shared VPC host"}
+	}
+	project.LifecycleState = "DELETE_REQUESTED"
+	return nil
+}
+
+func (f *fakeCloud) UpdateProjectLabels(ctx context.Context, projectId string, set map[string]string, remove ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("UpdateProjectLabels", projectId); err != nil {
+		return err
+	}
+	project, ok := f.projects[projectId]
+	if !ok {
+		return notFound(projectId)
+	}
+	if project.Labels == nil {
+		project.Labels = map[string]string{}
+	}
+	for key, value := range set {
+		project.Labels[key] = value
+	}
+	for _, key := range remove {
+		delete(project.Labels, key)
+	}
+	return nil
+}
+
+func (f *fakeCloud) ListLiens(ctx context.Context, parent string, page func(*cloudresourcemanager.ListLiensResponse) error) error {
+	defer f.track(apiResourceManager)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return page(&cloudresourcemanager.ListLiensResponse{Liens: f.liens[parent]})
+}
+
+func (f *fakeCloud) DeleteLien(ctx context.Context, name string) error {
+	defer f.track(apiResourceManager)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteLien", name); err != nil {
+		return err
+	}
+	for parent, liens := range f.liens {
+		for i, lien := range liens {
+			if lien.Name == name {
+				f.liens[parent] = append(liens[:i:i], liens[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return notFound(name)
+}
+
+func (f *fakeCloud) GetFolder(ctx context.Context, name string) (*cloudresourcemanager2.Folder, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["GetFolder "+name]; err != nil {
+		return nil, err
+	}
+	folder, ok := f.folders[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return folder, nil
+}
+
+func (f *fakeCloud) ListFolders(ctx context.Context, parent string, page func(*cloudresourcemanager2.ListFoldersResponse) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["ListFolders "+parent]; err != nil {
+		return err
+	}
+	response := &cloudresourcemanager2.ListFoldersResponse{}
+	for _, folder := range f.folders {
+		if folder.Parent == parent && folder.LifecycleState == "ACTIVE" {
+			response.Folders = append(response.Folders, folder)
+		}
+	}
+	return page(response)
+}
+
+func (f *fakeCloud) DeleteFolder(ctx context.Context, name string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteFolder", name); err != nil {
+		return nil, err
+	}
+	folder, ok := f.folders[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	folder.LifecycleState = "DELETE_REQUESTED"
+	return f.operation("DeleteFolder", name), nil
+}
+
+func (f *fakeCloud) ListTagKeys(ctx context.Context, parent string, pageSize int64, page func(*cloudresourcemanager3.ListTagKeysResponse) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePages(f.tagKeys, pageSize, f.failures["ListTagKeys "+parent], func(items []*cloudresourcemanager3.TagKey) error {
+		return page(&cloudresourcemanager3.ListTagKeysResponse{TagKeys: items})
+	})
+}
+
+func (f *fakeCloud) DeleteTagKey(ctx context.Context, name string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteTagKey", name); err != nil {
+		return nil, err
+	}
+	return f.operation("DeleteTagKey", name), nil
+}
+
+func (f *fakeCloud) ListTagValues(ctx context.Context, parent string, pageSize int64, page func(*cloudresourcemanager3.ListTagValuesResponse) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePages(f.tagValues[parent], pageSize, f.failures["ListTagValues "+parent], func(items []*cloudresourcemanager3.TagValue) error {
+		return page(&cloudresourcemanager3.ListTagValuesResponse{TagValues: items})
+	})
+}
+
+func (f *fakeCloud) DeleteTagValue(ctx context.Context, name string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteTagValue", name); err != nil {
+		return nil, err
+	}
+	return f.operation("DeleteTagValue", name), nil
+}
+
+func (f *fakeCloud) ListEffectiveTags(ctx context.Context, parent string, page func(*cloudresourcemanager3.ListEffectiveTagsResponse) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["ListEffectiveTags "+parent]; err != nil {
+		return err
+	}
+	return page(&cloudresourcemanager3.ListEffectiveTagsResponse{EffectiveTags: f.effectiveTags[parent]})
+}
+
+func (f *fakeCloud) ListNotificationConfigs(ctx context.Context, req *securitycenterpb.ListNotificationConfigsRequest, config func(*securitycenterpb.NotificationConfig)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePages(f.notificationConfigs, 1, f.failures["ListNotificationConfigs "+req.Parent], func(items []*securitycenterpb.NotificationConfig) error {
+		for _, c := range items {
+			config(c)
+		}
+		return nil
+	})
+}
+
+func (f *fakeCloud) DeleteNotificationConfig(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record("DeleteNotificationConfig", name)
+}
+
+func (f *fakeCloud) ListFeeds(ctx context.Context, parent string) (*assetpb.ListFeedsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &assetpb.ListFeedsResponse{Feeds: f.feeds}, nil
+}
+
+func (f *fakeCloud) DeleteFeed(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record("DeleteFeed", name)
+}
+
+func (f *fakeCloud) ListBillingSinks(ctx context.Context, parent string, pageSize int64, page func(*logging.ListSinksResponse) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePages(f.billingSinks, pageSize, f.failures["ListBillingSinks "+parent], func(items []*logging.LogSink) error {
+		return page(&logging.ListSinksResponse{Sinks: items})
+	})
+}
+
+func (f *fakeCloud) DeleteBillingSink(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.record("DeleteBillingSink", name)
+}
+
+func (f *fakeCloud) ListFirewallPolicies(ctx context.Context, parentId string, pageSize int64, page func(*compute.FirewallPolicyList) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePages(f.firewallPolicies[parentId], pageSize, f.failures["ListFirewallPolicies "+parentId], func(items []*compute.FirewallPolicy) error {
+		return page(&compute.FirewallPolicyList{Items: items})
+	})
+}
+
+func (f *fakeCloud) RemoveFirewallPolicyAssociation(ctx context.Context, policy string, association string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("RemoveFirewallPolicyAssociation", policy+"/"+association); err != nil {
+		return nil, err
+	}
+	return f.operation("RemoveFirewallPolicyAssociation", policy+"/"+association), nil
+}
+
+func (f *fakeCloud) DeleteFirewallPolicy(ctx context.Context, policy string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteFirewallPolicy", policy); err != nil {
+		return nil, err
+	}
+	return f.operation("DeleteFirewallPolicy", policy), nil
+}
+
+func (f *fakeCloud) ListServices(ctx context.Context, producerProjectId string, pageSize int64, page func(*servicemanagement.ListServicesResponse) error) error {
+	defer f.track(apiServiceManagement)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePages(f.services[producerProjectId], pageSize, f.failures["ListServices "+producerProjectId], func(items []*servicemanagement.ManagedService) error {
+		return page(&servicemanagement.ListServicesResponse{Services: items})
+	})
+}
+
+func (f *fakeCloud) DeleteService(ctx context.Context, serviceName string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteService", serviceName); err != nil {
+		return nil, err
+	}
+	for projectId, services := range f.services {
+		for i, service := range services {
+			if service.ServiceName == serviceName {
+				f.services[projectId] = append(services[:i:i], services[i+1:]...)
+				return f.operation("DeleteService", serviceName), nil
+			}
+		}
+	}
+	return nil, notFound(serviceName)
+}
+
+func (f *fakeCloud) ListBuckets(ctx context.Context, projectId string, page func(*storage.Buckets) error) error {
+	defer f.track(apiStorage)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePages(f.buckets[projectId], 0, f.failures["ListBuckets "+projectId], func(items []*storage.Bucket) error {
+		return page(&storage.Buckets{Items: items})
+	})
+}
+
+func (f *fakeCloud) IsXpnHost(ctx context.Context, projectId string) (bool, error) {
+	defer f.track(apiCompute)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["IsXpnHost "+projectId]; err != nil {
+		return false, err
+	}
+	_, ok := f.xpnResources[projectId]
+	return ok, nil
+}
+
+func (f *fakeCloud) ListXpnResources(ctx context.Context, projectId string, page func(*compute.ProjectsGetXpnResources) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fakePages(f.xpnResources[projectId], 0, f.failures["ListXpnResources "+projectId], func(items []*compute.XpnResourceId) error {
+		return page(&compute.ProjectsGetXpnResources{Resources: items})
+	})
+}
+
+func (f *fakeCloud) DisableXpnResource(ctx context.Context, hostProjectId string, serviceProjectId string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := hostProjectId + "/" + serviceProjectId
+	if err := f.record("DisableXpnResource", name); err != nil {
+		return nil, err
+	}
+	resources := f.xpnResources[hostProjectId]
+	for i, resource := range resources {
+		if resource.Id == serviceProjectId {
+			f.xpnResources[hostProjectId] = append(resources[:i:i], resources[i+1:]...)
+			return f.operation("DisableXpnResource", name), nil
+		}
+	}
+	return nil, notFound(name)
+}
+
+func (f *fakeCloud) DisableXpnHost(ctx context.Context, projectId string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DisableXpnHost", projectId); err != nil {
+		return nil, err
+	}
+	if len(f.xpnResources[projectId]) > 0 {
+		return nil, &googleapi.Error{Code: 400, Message: "service projects are still attached"}
+	}
+	delete(f.xpnResources, projectId)
+	return f.operation("DisableXpnHost", projectId), nil
+}
+
+func (f *fakeCloud) ListClusters(ctx context.Context, parent string) (*containerpb.ListClustersResponse, error) {
+	defer f.track(apiContainer)()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	projectId := strings.Split(parent, "/")[1]
+	return &containerpb.ListClustersResponse{Clusters: f.clusters[projectId]}, nil
+}
+
+func (f *fakeCloud) DeleteCluster(ctx context.Context, name string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteCluster", name); err != nil {
+		return nil, err
+	}
+	// Cluster deletions take minutes, they keep running unless the test sets their polls.
+	if _, ok := f.operations["DeleteCluster "+name]; !ok {
+		f.operations["DeleteCluster "+name] = -1
+	}
+	projectId := strings.Split(name, "/")[1]
+	for _, cluster := range f.clusters[projectId] {
+		if strings.HasSuffix(name, "/clusters/"+cluster.Name) {
+			cluster.Status = containerpb.Cluster_STOPPING
+			return f.operation("DeleteCluster", name), nil
+		}
+	}
+	return nil, notFound(name)
+}
+
+// DeletionOperation returns the operation of an earlier DeleteCluster call set by the test.
+func (f *fakeCloud) DeletionOperation(ctx context.Context, name string) (*operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failures["DeletionOperation "+name]; err != nil {
+		return nil, err
+	}
+	return f.operation("DeleteCluster", name), nil
+}
+
+type recordingReportSink struct {
+	reports [][]byte
+}
+
+func (s *recordingReportSink) WriteReport(ctx context.Context, report *runReport, data []byte) error {
+	s.reports = append(s.reports, data)
+	return nil
+}
+
+type recordingNotifier struct {
+	notifications []notification
+}
+
+func (s *recordingNotifier) Notify(ctx context.Context, n notification) error {
+	s.notifications = append(s.notifications, n)
+	return nil
+}
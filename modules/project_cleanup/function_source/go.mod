@@ -6,11 +6,13 @@ toolchain go1.23.6
 
 require (
 	cloud.google.com/go/asset v1.20.4
+	cloud.google.com/go/compute/metadata v0.6.0
 	cloud.google.com/go/container v1.42.2
 	cloud.google.com/go/securitycenter v1.35.3
 	golang.org/x/net v0.34.0
 	golang.org/x/oauth2 v0.26.0
 	google.golang.org/api v0.219.0
+	google.golang.org/grpc v1.70.0
 )
 
 require (
@@ -18,7 +20,6 @@ require (
 	cloud.google.com/go/accesscontextmanager v1.9.3 // indirect
 	cloud.google.com/go/auth v0.14.0 // indirect
 	cloud.google.com/go/auth/oauth2adapt v0.2.7 // indirect
-	cloud.google.com/go/compute/metadata v0.6.0 // indirect
 	cloud.google.com/go/iam v1.3.1 // indirect
 	cloud.google.com/go/longrunning v0.6.4 // indirect
 	cloud.google.com/go/orgpolicy v1.14.2 // indirect
@@ -46,6 +47,5 @@ require (
 	google.golang.org/genproto v0.0.0-20250122153221-138b5a5a4fd4 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20250124145028-65684f501c47 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250124145028-65684f501c47 // indirect
-	google.golang.org/grpc v1.70.0 // indirect
 	google.golang.org/protobuf v1.36.4 // indirect
 )
@@ -0,0 +1,198 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LabelSelector matches a set of labels against groups of requirements, similar to
+// Kubernetes label selectors. Requirements within a group are separated by commas and must
+// all match, groups are separated by "||" and at least one of them must match, e.g.
+// "env in (ci,test), !owner || sandbox=true". Commas and "||" within parentheses, braces or
+// brackets don't separate anything, so that regular expressions like "name =~ ci-[a-z]{2,6}"
+// can use them. The zero value matches nothing.
+type LabelSelector struct {
+	raw    string
+	groups [][]labelRequirement
+}
+
+// Operators supported in label requirements.
+const (
+	selectorExists   = "exists"
+	selectorAbsent   = "!"
+	selectorEquals   = "="
+	selectorNotEqual = "!="
+	selectorIn       = "in"
+	selectorNotIn    = "notin"
+	selectorMatches  = "=~"
+	selectorNotMatch = "!~"
+)
+
+type labelRequirement struct {
+	key      string
+	operator string
+	values   []string
+	regex    *regexp.Regexp
+}
+
+var (
+	selectorKeyRegexp        = regexp.MustCompile(`^[\w.\-/]+$`)
+	selectorSetRegexp        = regexp.MustCompile(`^([\w.\-/]+)\s+(in|notin)\s+\((.*)\)$`)
+	selectorComparisonRegexp = regexp.MustCompile(`^([\w.\-/]+)\s*(!~|=~|!=|==|=)\s*(.*)$`)
+)
+
+// ParseLabelSelector parses the selector syntax described on LabelSelector.
+// An empty string returns the zero value.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	result := LabelSelector{raw: strings.TrimSpace(selector)}
+	if result.raw == "" {
+		return result, nil
+	}
+	for _, group := range splitTopLevel(result.raw, "||") {
+		var requirements []labelRequirement
+		for _, term := range splitTopLevel(group, ",") {
+			requirement, err := parseLabelRequirement(strings.TrimSpace(term))
+			if err != nil {
+				return LabelSelector{}, fmt.Errorf("invalid label selector [%s], %s", result.raw, err.Error())
+			}
+			requirements = append(requirements, requirement)
+		}
+		result.groups = append(result.groups, requirements)
+	}
+	return result, nil
+}
+
+// splitTopLevel splits s on the separators which are not within parentheses, braces or the
+// brackets of a character class, skipping the characters escaped with a backslash.
+func splitTopLevel(s string, separator string) []string {
+	var terms []string
+	depth, start, inClass := 0, 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], separator):
+			terms = append(terms, s[start:i])
+			start = i + len(separator)
+			i = start - 1
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseLabelRequirement(term string) (labelRequirement, error) {
+	if term == "" {
+		return labelRequirement{}, fmt.Errorf("empty requirement")
+	}
+	if strings.HasPrefix(term, "!") && selectorKeyRegexp.MatchString(strings.TrimSpace(term[1:])) {
+		return labelRequirement{key: strings.TrimSpace(term[1:]), operator: selectorAbsent}, nil
+	}
+	if selectorKeyRegexp.MatchString(term) {
+		return labelRequirement{key: term, operator: selectorExists}, nil
+	}
+	if m := selectorSetRegexp.FindStringSubmatch(term); m != nil {
+		var values []string
+		for _, value := range strings.Split(m[3], ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+		return labelRequirement{key: m[1], operator: m[2], values: values}, nil
+	}
+	if m := selectorComparisonRegexp.FindStringSubmatch(term); m != nil {
+		requirement := labelRequirement{key: m[1], operator: m[2], values: []string{strings.TrimSpace(m[3])}}
+		switch requirement.operator {
+		case "==":
+			requirement.operator = selectorEquals
+		case selectorMatches, selectorNotMatch:
+			regex, err := regexp.Compile("^(?:" + requirement.values[0] + ")$")
+			if err != nil {
+				return labelRequirement{}, fmt.Errorf("invalid regular expression in [%s], error [%s]", term, err.Error())
+			}
+			requirement.regex = regex
+		}
+		return requirement, nil
+	}
+	return labelRequirement{}, fmt.Errorf("unsupported requirement [%s]", term)
+}
+
+func (r labelRequirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.operator {
+	case selectorExists:
+		return ok
+	case selectorAbsent:
+		return !ok
+	case selectorEquals:
+		return ok && value == r.values[0]
+	case selectorNotEqual:
+		return !ok || value != r.values[0]
+	case selectorIn:
+		return ok && containsString(r.values, value)
+	case selectorNotIn:
+		return !ok || !containsString(r.values, value)
+	case selectorMatches:
+		return ok && r.regex.MatchString(value)
+	case selectorNotMatch:
+		return !ok || !r.regex.MatchString(value)
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty reports whether the selector has no requirements.
+func (s LabelSelector) isEmpty() bool {
+	return len(s.groups) == 0
+}
+
+// matches reports whether every requirement of at least one group matches labels.
+func (s LabelSelector) matches(labels map[string]string) bool {
+	for _, group := range s.groups {
+		matched := true
+		for _, requirement := range group {
+			if !requirement.matches(labels) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (s LabelSelector) String() string {
+	return s.raw
+}
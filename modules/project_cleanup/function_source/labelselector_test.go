@@ -0,0 +1,75 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"testing"
+)
+
+func TestLabelSelectorMatches(t *testing.T) {
+	for _, tc := range []struct {
+		selector string
+		labels   map[string]string
+		want     bool
+	}{
+		{selector: "env", labels: map[string]string{"env": "ci"}, want: true},
+		{selector: "env", labels: map[string]string{}, want: false},
+		{selector: "!owner", labels: map[string]string{"env": "ci"}, want: true},
+		{selector: "!owner", labels: map[string]string{"owner": "me"}, want: false},
+		{selector: "env=ci", labels: map[string]string{"env": "ci"}, want: true},
+		{selector: "env==ci", labels: map[string]string{"env": "test"}, want: false},
+		{selector: "env!=ci", labels: map[string]string{}, want: true},
+		{selector: "env in (ci, test)", labels: map[string]string{"env": "test"}, want: true},
+		{selector: "env in (ci,test)", labels: map[string]string{"env": "prod"}, want: false},
+		{selector: "env notin (prod)", labels: map[string]string{"env": "ci"}, want: true},
+		{selector: "env notin (prod)", labels: map[string]string{"env": "prod"}, want: false},
+		{selector: "branch=~pr-[0-9]+", labels: map[string]string{"branch": "pr-12"}, want: true},
+		{selector: "branch=~pr-[0-9]+", labels: map[string]string{"branch": "pr-12-main"}, want: false},
+		{selector: "branch!~main|release-.*", labels: map[string]string{"branch": "feature"}, want: true},
+		{selector: "env in (ci,test), !owner", labels: map[string]string{"env": "ci"}, want: true},
+		{selector: "env in (ci,test), !owner", labels: map[string]string{"env": "ci", "owner": "me"}, want: false},
+		{selector: "env in (ci,test), !owner || sandbox=true", labels: map[string]string{"owner": "me", "sandbox": "true"}, want: true},
+		{selector: "name =~ ci-[a-z]{2,6}, env=ci", labels: map[string]string{"name": "ci-abc", "env": "ci"}, want: true},
+		{selector: "name =~ ci-[a-z]{2,6}", labels: map[string]string{"name": "ci-a"}, want: false},
+		{selector: "name =~ ci-[,|]+ || env=ci", labels: map[string]string{"name": "ci-,|"}, want: true},
+		{selector: "name =~ (ci||qa)-.*", labels: map[string]string{"name": "-x"}, want: true},
+		{selector: `name =~ ci\,.*, env=ci`, labels: map[string]string{"name": "ci,1", "env": "ci"}, want: true},
+		{selector: `name =~ ci\[[0-9]\], env=ci`, labels: map[string]string{"name": "ci[1]", "env": "ci"}, want: true},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			selector, err := ParseLabelSelector(tc.selector)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got := selector.matches(tc.labels); got != tc.want {
+				t.Errorf("matches(%v) = %t, want %t", tc.labels, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseLabelSelectorErrors(t *testing.T) {
+	for _, selector := range []string{"env=ci,", "env in ci", "branch=~pr-(", "env ? ci", "a || "} {
+		if _, err := ParseLabelSelector(selector); err == nil {
+			t.Errorf("ParseLabelSelector(%q) should fail", selector)
+		}
+	}
+	selector, err := ParseLabelSelector("  ")
+	if err != nil || !selector.isEmpty() {
+		t.Errorf("an empty selector should parse to the zero value, got %v, %v", selector, err)
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severities understood by Cloud Logging when parsing JSON lines written to stdout.
+const (
+	severityDebug   = "DEBUG"
+	severityInfo    = "INFO"
+	severityNotice  = "NOTICE"
+	severityWarning = "WARNING"
+	severityError   = "ERROR"
+)
+
+// Actions recorded for every resource the cleaner looks at.
+const (
+	actionList     = "list"
+	actionDelete   = "delete"
+	actionSkip     = "skip"
+	actionDefer    = "defer"
+	actionSchedule = "schedule"
+	actionRetry    = "retry"
+	actionBlock    = "block"
+)
+
+// Resource types recorded in the action log entries.
+const (
+	resourceProject                   = "project"
+	resourceLien                      = "lien"
+	resourceFolder                    = "folder"
+	resourceTagKey                    = "tag_key"
+	resourceTagValue                  = "tag_value"
+	resourceEffectiveTag              = "effective_tag"
+	resourceSCCNotification           = "scc_notification"
+	resourceFeed                      = "feed"
+	resourceBillingSink               = "billing_sink"
+	resourceFirewallPolicy            = "firewall_policy"
+	resourceFirewallPolicyAssociation = "firewall_policy_association"
+	resourceEndpointsService          = "endpoints_service"
+	resourceCluster                   = "cluster"
+	resourceBucket                    = "bucket"
+	resourceSharedVPCServiceProject   = "shared_vpc_service_project"
+	resourceSharedVPCHost             = "shared_vpc_host"
+)
+
+// logEntry is a single structured log line, see https://cloud.google.com/logging/docs/structured-logging.
+type logEntry struct {
+	Severity     string     `json:"severity"`
+	Message      string     `json:"message"`
+	RunID        string     `json:"run_id,omitempty"`
+	Action       string     `json:"action,omitempty"`
+	ResourceType string     `json:"resource_type,omitempty"`
+	ResourceName string     `json:"resource_name,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	DryRun       bool       `json:"dry_run,omitempty"`
+	Report       *runReport `json:"report,omitempty"`
+}
+
+// structuredLogger writes Cloud Logging JSON entries, one per line.
+type structuredLogger struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	runID string
+}
+
+func newStructuredLogger(out io.Writer) *structuredLogger {
+	return &structuredLogger{mu: &sync.Mutex{}, out: out}
+}
+
+// withRunID returns a logger sharing the same output which tags every entry with runID.
+func (l *structuredLogger) withRunID(runID string) *structuredLogger {
+	return &structuredLogger{mu: l.mu, out: l.out, runID: runID}
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+func (l *structuredLogger) write(entry logEntry) {
+	entry.RunID = l.runID
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"severity":%q,"message":%q}`, severityError, "Failed to marshal log entry: "+err.Error()))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(append(line, '\n'))
+}
+
+func (l *structuredLogger) Printf(format string, v ...interface{}) {
+	l.write(logEntry{Severity: severityInfo, Message: fmt.Sprintf(format, v...)})
+}
+
+func (l *structuredLogger) Println(v ...interface{}) {
+	l.write(logEntry{Severity: severityInfo, Message: strings.TrimSuffix(fmt.Sprintln(v...), "\n")})
+}
+
+func (l *structuredLogger) Errorf(format string, v ...interface{}) {
+	l.write(logEntry{Severity: severityError, Message: fmt.Sprintf(format, v...)})
+}
+
+func resourceTypeText(resourceType string) string {
+	return strings.ReplaceAll(resourceType, "_", " ")
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// listed records a list call made under parent, err is nil when it succeeded.
+func (l *structuredLogger) listed(resourceType string, parent string, count int, err error) {
+	entry := logEntry{Action: actionList, ResourceType: resourceType, ResourceName: parent, Error: errorText(err)}
+	if err != nil {
+		entry.Severity = severityError
+		entry.Message = fmt.Sprintf("Failed to list %ss in [%s], error [%s]", resourceTypeText(resourceType), parent, err.Error())
+	} else {
+		entry.Severity = severityInfo
+		entry.Message = fmt.Sprintf("Got [%d] %ss in [%s]", count, resourceTypeText(resourceType), parent)
+	}
+	l.write(entry)
+}
+
+// deleted records a delete call for the resource, err is nil when it succeeded.
+func (l *structuredLogger) deleted(resourceType string, name string, err error) {
+	entry := logEntry{Action: actionDelete, ResourceType: resourceType, ResourceName: name, Error: errorText(err)}
+	if err != nil {
+		entry.Severity = severityError
+		entry.Message = fmt.Sprintf("Failed to delete %s [%s], error [%s]", resourceTypeText(resourceType), name, err.Error())
+	} else {
+		entry.Severity = severityNotice
+		entry.Message = fmt.Sprintf("Deleted %s [%s]", resourceTypeText(resourceType), name)
+	}
+	l.write(entry)
+}
+
+// planned records a deletion which was not made because of dry run.
+func (l *structuredLogger) planned(resourceType string, name string) {
+	l.write(logEntry{
+		Severity:     severityNotice,
+		Message:      fmt.Sprintf("Dry run, would delete %s [%s]", resourceTypeText(resourceType), name),
+		Action:       actionDelete,
+		ResourceType: resourceType,
+		ResourceName: name,
+		Reason:       "dry run",
+		DryRun:       true,
+	})
+}
+
+// skipped records a resource excluded from the clean up by a filter.
+func (l *structuredLogger) skipped(resourceType string, name string, reason string) {
+	l.write(logEntry{
+		Severity:     severityDebug,
+		Message:      fmt.Sprintf("Skipping %s [%s], %s", resourceTypeText(resourceType), name, reason),
+		Action:       actionSkip,
+		ResourceType: resourceType,
+		ResourceName: name,
+		Reason:       reason,
+	})
+}
+
+// deferred records a resource whose deletion is left to a later run.
+func (l *structuredLogger) deferred(resourceType string, name string, reason string) {
+	l.write(logEntry{
+		Severity:     severityInfo,
+		Message:      fmt.Sprintf("Defer deleting %s [%s], %s", resourceTypeText(resourceType), name, reason),
+		Action:       actionDefer,
+		ResourceType: resourceType,
+		ResourceName: name,
+		Reason:       reason,
+	})
+}
+
+// running records a deletion whose operation is still running after the timeout.
+func (l *structuredLogger) running(resourceType string, name string, reason string) {
+	l.write(logEntry{
+		Severity:     severityWarning,
+		Message:      fmt.Sprintf("Deletion of %s [%s] is still running, %s", resourceTypeText(resourceType), name, reason),
+		Action:       actionDelete,
+		ResourceType: resourceType,
+		ResourceName: name,
+		Reason:       reason,
+	})
+}
+
+// blocked records a resource which can't be deleted until the blocker is removed by hand.
+func (l *structuredLogger) blocked(resourceType string, name string, reason string) {
+	l.write(logEntry{
+		Severity:     severityWarning,
+		Message:      fmt.Sprintf("Cannot delete %s [%s], %s", resourceTypeText(resourceType), name, reason),
+		Action:       actionBlock,
+		ResourceType: resourceType,
+		ResourceName: name,
+		Reason:       reason,
+	})
+}
+
+// scheduled records a resource marked for deletion by a later run, err is nil when it succeeded.
+func (l *structuredLogger) scheduled(resourceType string, name string, reason string, err error) {
+	entry := logEntry{Action: actionSchedule, ResourceType: resourceType, ResourceName: name, Reason: reason, Error: errorText(err)}
+	if err != nil {
+		entry.Severity = severityError
+		entry.Message = fmt.Sprintf("Failed to schedule deletion of %s [%s], error [%s]", resourceTypeText(resourceType), name, err.Error())
+	} else {
+		entry.Severity = severityNotice
+		entry.Message = fmt.Sprintf("Scheduled deletion of %s [%s], %s", resourceTypeText(resourceType), name, reason)
+	}
+	l.write(entry)
+}
+
+// retrying records a failed call to api which is tried again after delay.
+func (l *structuredLogger) retrying(api string, err error, delay time.Duration) {
+	l.write(logEntry{
+		Severity: severityWarning,
+		Message:  fmt.Sprintf("Retrying call to [%s] in %s, error [%s]", api, delay.Round(time.Millisecond), errorText(err)),
+		Action:   actionRetry,
+		Error:    errorText(err),
+	})
+}
+
+// summary writes the counts of the run report as a single entry.
+func (l *structuredLogger) summary(report *runReport) {
+	severity := severityNotice
+	if len(report.Errors) > 0 {
+		severity = severityError
+	}
+	l.write(logEntry{
+		Severity: severity,
+		Message:  fmt.Sprintf("Clean up run finished, %s, %d errors", report.summary(), len(report.Errors)),
+		DryRun:   report.DryRun,
+		Report:   report.counts(),
+	})
+}
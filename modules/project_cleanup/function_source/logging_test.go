@@ -0,0 +1,99 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStructuredLoggerWritesCloudLoggingEntries(t *testing.T) {
+	var out bytes.Buffer
+	l := newStructuredLogger(&out).withRunID("run-1")
+
+	l.deleted(resourceProject, "p1", errors.New("permission denied"))
+	l.skipped(resourceFolder, "folders/2", "root folder")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %q", len(lines), out.String())
+	}
+	want := []logEntry{
+		{
+			Severity:     severityError,
+			Message:      "Failed to delete project [p1], error [permission denied]",
+			RunID:        "run-1",
+			Action:       actionDelete,
+			ResourceType: resourceProject,
+			ResourceName: "p1",
+			Error:        "permission denied",
+		},
+		{
+			Severity:     severityDebug,
+			Message:      "Skipping folder [folders/2], root folder",
+			RunID:        "run-1",
+			Action:       actionSkip,
+			ResourceType: resourceFolder,
+			ResourceName: "folders/2",
+			Reason:       "root folder",
+		},
+	}
+	for i, line := range lines {
+		var got logEntry
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		if got != want[i] {
+			t.Errorf("got entry %+v, want %+v", got, want[i])
+		}
+	}
+}
+
+func TestStructuredLoggerLogsTheCountsOfTheReport(t *testing.T) {
+	var out bytes.Buffer
+	l := newStructuredLogger(&out)
+	report := newRunReport("run-1", testConfig(), time.Now())
+	report.addDeleted(resourceProject, "old")
+	for i := 0; i < 1000; i++ {
+		report.addSkipped(resourceProject, fmt.Sprintf("project-%d", i), "one of the excluded labels present")
+	}
+	for i := 0; i < maxLoggedErrors+5; i++ {
+		report.addError(fmt.Errorf("error %d", i))
+	}
+
+	l.summary(report)
+
+	var got logEntry
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", out.String(), err)
+	}
+	projects := got.Report.Resources[resourceProject]
+	if projects.DeletedCount != 1 || projects.SkippedCount != 1000 || len(projects.Deleted) != 0 || len(projects.Skipped) != 0 {
+		t.Errorf("the logged report should hold the counts only, got %+v", projects)
+	}
+	if len(got.Report.Errors) != maxLoggedErrors+1 || got.Report.Errors[maxLoggedErrors] != "and 5 more errors, see the full report" {
+		t.Errorf("got logged errors %v, want the first %d and a note", got.Report.Errors, maxLoggedErrors)
+	}
+	if len(report.resource(resourceProject).Skipped) != 1000 || len(report.Errors) != maxLoggedErrors+5 {
+		t.Errorf("the full report should be left as is")
+	}
+}
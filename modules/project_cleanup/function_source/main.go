@@ -17,10 +17,10 @@ limitations under the License.
 package project_cleanup
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
 	"os"
 	"regexp"
 	"strconv"
@@ -28,33 +28,57 @@ import (
 	"time"
 
 	asset "cloud.google.com/go/asset/apiv1"
-	"cloud.google.com/go/asset/apiv1/assetpb"
+	"cloud.google.com/go/compute/metadata"
 	container "cloud.google.com/go/container/apiv1"
-	"cloud.google.com/go/container/apiv1/containerpb"
 	securitycenter "cloud.google.com/go/securitycenter/apiv1"
-	"cloud.google.com/go/securitycenter/apiv1/securitycenterpb"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/cloudresourcemanager/v1"
 	cloudresourcemanager2 "google.golang.org/api/cloudresourcemanager/v2"
 	cloudresourcemanager3 "google.golang.org/api/cloudresourcemanager/v3"
 	"google.golang.org/api/compute/v1"
-	"google.golang.org/api/googleapi"
-	"google.golang.org/api/iterator"
 	"google.golang.org/api/logging/v2"
 	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
 	"google.golang.org/api/servicemanagement/v1"
+	"google.golang.org/api/storage/v1"
 )
 
 const (
 	LifecycleStateActiveRequested = "ACTIVE"
 	TargetExcludedLabels          = "TARGET_EXCLUDED_LABELS"
 	TargetIncludedLabels          = "TARGET_INCLUDED_LABELS"
+	TargetExcludedLabelSelector   = "TARGET_EXCLUDED_LABEL_SELECTOR"
+	TargetIncludedLabelSelector   = "TARGET_INCLUDED_LABEL_SELECTOR"
+	TargetExcludedTags            = "TARGET_EXCLUDED_TAGS"
+	TargetIncludedTags            = "TARGET_INCLUDED_TAGS"
+	TargetExcludedProjects        = "TARGET_EXCLUDED_PROJECTS"
+	TargetIncludedProjects        = "TARGET_INCLUDED_PROJECTS"
+	PreservedLiens                = "PRESERVED_LIENS"
+	RemovableLienOrigins          = "REMOVABLE_LIEN_ORIGINS"
+	expiresAtLabel                = "expires-at"
+	ttlHoursLabel                 = "ttl-hours"
+	cleanupScheduledAtLabel       = "cleanup-scheduled-at"
+	cleanupScheduledLabel         = "cleanup-scheduled"
+	DeletionGracePeriodHours      = "DELETION_GRACE_PERIOD_HOURS"
+	NotifySlackWebhookURL         = "NOTIFY_SLACK_WEBHOOK_URL"
+	NotifyGoogleChatWebhookURL    = "NOTIFY_GOOGLE_CHAT_WEBHOOK_URL"
+	NotifyWebhookURL              = "NOTIFY_WEBHOOK_URL"
+	NotifySMTPAddress             = "NOTIFY_SMTP_ADDRESS"
+	NotifySMTPFrom                = "NOTIFY_SMTP_FROM"
+	NotifySMTPTo                  = "NOTIFY_SMTP_TO"
+	NotifySMTPUsername            = "NOTIFY_SMTP_USERNAME"
+	NotifySMTPPassword            = "NOTIFY_SMTP_PASSWORD"
+	webhookURLRegexp              = `^https?://\S+$`
+	smtpAddressRegexp             = `^[^:\s]+:[0-9]+$`
+	namespacedTagRegexp           = `^[^/]+/[^/]+(/[^/]+)?$`
 	CleanUpTagKeys                = "CLEAN_UP_TAG_KEYS"
 	CleanUpSCCNotfi               = "CLEAN_UP_SCC_NOTIFICATIONS"
 	TargetExcludedTagKeys         = "TARGET_EXCLUDED_TAGKEYS"
 	TargetIncludedSCCNotfis       = "TARGET_INCLUDED_SCC_NOTIFICATIONS"
 	TargetFolderId                = "TARGET_FOLDER_ID"
+	TargetFolders                 = "TARGET_FOLDERS"
+	CleanUpOrganizationProjects   = "CLEAN_UP_ORGANIZATION_PROJECTS"
 	TargetOrganizationId          = "TARGET_ORGANIZATION_ID"
 	MaxProjectAgeHours            = "MAX_PROJECT_AGE_HOURS"
 	targetFolderRegexp            = `^[0-9]+$`
@@ -67,106 +91,123 @@ const (
 	CleanUpBillingSinks           = "CLEAN_UP_BILLING_SINKS"
 	TargetBillingSinks            = "TARGET_BILLING_SINKS"
 	BillingSinksPageSize          = "BILLING_SINKS_PAGE_SIZE"
+	TagKeysPageSize               = "TAG_KEYS_PAGE_SIZE"
+	TagValuesPageSize             = "TAG_VALUES_PAGE_SIZE"
+	FirewallPoliciesPageSize      = "FIREWALL_POLICIES_PAGE_SIZE"
+	EndpointsServicesPageSize     = "ENDPOINTS_SERVICES_PAGE_SIZE"
+	DryRun                        = "DRY_RUN"
+	ReportGCSBucket               = "REPORT_GCS_BUCKET"
+	ReportGCSPrefix               = "REPORT_GCS_PREFIX"
+	ReportPubSubTopic             = "REPORT_PUBSUB_TOPIC"
+	ReturnErrorOnFailure          = "RETURN_ERROR_ON_FAILURE"
+	ProjectParallelism            = "PROJECT_PARALLELISM"
+	MaxConcurrentAPICalls         = "MAX_CONCURRENT_API_CALLS"
+	pubSubTopicRegexp             = `^projects/[^/]+/topics/[^/]+$`
+	TargetIncludedFolders         = "TARGET_INCLUDED_FOLDERS"
+	TargetExcludedFolders         = "TARGET_EXCLUDED_FOLDERS"
+	TargetExcludedFolderTags      = "TARGET_EXCLUDED_FOLDER_TAGS"
+	FolderDeletionMode            = "FOLDER_DELETION_MODE"
+	ProtectedFolderDepth          = "PROTECTED_FOLDER_DEPTH"
+	RetryMaxAttempts              = "RETRY_MAX_ATTEMPTS"
+	RetryInitialDelayMs           = "RETRY_INITIAL_DELAY_MS"
+	RetryMaxElapsedSeconds        = "RETRY_MAX_ELAPSED_SECONDS"
+	OperationTimeoutSeconds       = "OPERATION_TIMEOUT_SECONDS"
+	OperationPollIntervalSeconds  = "OPERATION_POLL_INTERVAL_SECONDS"
+	ClusterDeletionTimeoutSeconds = "CLUSTER_DELETION_TIMEOUT_SECONDS"
+	ClusterStoppingAlertHours     = "CLUSTER_STOPPING_ALERT_HOURS"
+	FunctionTimeoutSeconds        = "FUNCTION_TIMEOUT_SECONDS"
+	DeadlineMarginSeconds         = "DEADLINE_MARGIN_SECONDS"
+	CheckpointGCSBucket           = "CHECKPOINT_GCS_BUCKET"
+	CheckpointGCSObject           = "CHECKPOINT_GCS_OBJECT"
+	HostProjectId                 = "HOST_PROJECT_ID"
+	ProtectedResources            = "PROTECTED_RESOURCES"
+	protectedResourceRegexp       = `^(projects/[^/]+|folders/[0-9]+|tagKeys/[0-9]+|billingAccounts/[^/]+/sinks/[^/]+)$`
+	folderDeletionModeRegexp      = `^(always|empty)$`
+	folderDeletionAlways          = "always"
+	folderDeletionEmpty           = "empty"
 )
 
-var (
-	logger                 = log.New(os.Stdout, "", 0)
-	excludedLabelsMap      = getLabelsMapFromEnv(TargetExcludedLabels)
-	includedLabelsMap      = getLabelsMapFromEnv(TargetIncludedLabels)
-	cleanUpTagKeys         = getBoolFromEnv(CleanUpTagKeys)
-	cleanUpSCCNotfi        = getBoolFromEnv(CleanUpSCCNotfi)
-	excludedTagKeysList    = getTagKeysListFromEnv(TargetExcludedTagKeys)
-	includedSCCNotfisList  = getRegexListFromEnv(TargetIncludedSCCNotfis)
-	resourceCreationCutoff = getOldTime(getIntFromEnv(MaxProjectAgeHours) * 60 * 60)
-	rootFolderId           = getCorrectFolderIdOrTerminateExecution()
-	organizationId         = getCorrectOrganizationIdOrTerminateExecution()
-	sccPageSize            = int32(getIntFromEnv(SCCNotificationsPageSize))
-	cleanUpCaiFeeds        = getBoolFromEnv(CleanUpCaiFeeds)
-	includedFeedsList      = getRegexListFromEnv(TargetIncludedFeeds)
-	billingAccount         = getBillingAccountOrTerminateExecution()
-	cleanUpBillingSinks    = getBoolFromEnv(CleanUpBillingSinks)
-	billingSinksPageSize   = getIntFromEnv(BillingSinksPageSize)
-	targetBillingSinks     = getRegexListFromEnv(TargetBillingSinks)
-)
+var logger = newStructuredLogger(os.Stdout)
 
 type PubSubMessage struct {
 	Data []byte `json:"data"`
 }
 
-type FolderRecursion func(*cloudresourcemanager2.Folder, FolderRecursion)
-
-func activeProjectFilter(project *cloudresourcemanager.Project) bool {
-	return project.LifecycleState == LifecycleStateActiveRequested
-}
-
-func getOldTime(i int64) time.Time {
-	return time.Unix(time.Now().Unix()-i, 0)
+// invocationOptions holds the settings that can be provided in the Pub/Sub message payload
+// to override the configuration for a single run, e.g. {"dry_run": true}. Payloads which are
+// not JSON objects, like the default scheduler message, carry no options.
+type invocationOptions struct {
+	projectFilters
+	DryRun                      *bool          `json:"dry_run"`
+	TargetFolderId              *string        `json:"target_folder_id"`
+	TargetFolders               []TargetFolder `json:"target_folders"`
+	CleanUpOrganizationProjects *bool          `json:"clean_up_organization_projects"`
+	CleanUpTagKeys              *bool          `json:"clean_up_tag_keys"`
+	CleanUpSCCNotifications     *bool          `json:"clean_up_scc_notifications"`
+	CleanUpCaiFeeds             *bool          `json:"clean_up_cai_feeds"`
+	CleanUpBillingSinks         *bool          `json:"clean_up_billing_sinks"`
 }
 
-// isRetryableError checks if an error can be retried based on err code
-func isRetryableError(e error) bool {
-	gerr, ok := e.(*googleapi.Error)
-	if !ok {
-		return false
+func getInvocationOptions(m PubSubMessage) (invocationOptions, error) {
+	var options invocationOptions
+	if !strings.HasPrefix(strings.TrimSpace(string(m.Data)), "{") {
+		return options, nil
 	}
-	if gerr.Code == 429 || gerr.Code == 500 || gerr.Code == 502 || gerr.Code == 503 {
-		logger.Printf("Got retryable err %d: %s", gerr.Code, gerr.Message)
-		return true
+	decoder := json.NewDecoder(bytes.NewReader(m.Data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&options); err != nil {
+		return invocationOptions{}, fmt.Errorf("failed to parse Pub/Sub message payload [%s], error [%s]", string(m.Data), err.Error())
 	}
-	logger.Printf("Got non retryable err %d: %s", gerr.Code, gerr.Message)
-	return false
+	return options, nil
 }
 
-func retry(retryFunc func() error, tries int, duration time.Duration) error {
-	err := retryFunc()
-	if err == nil {
-		return nil
+// apply returns config with the options provided in the payload overriding it.
+// target_folder_id and target_folders replace every configured target folder.
+func (o invocationOptions) apply(config Config) (Config, error) {
+	config, errs := o.projectFilters.apply(config)
+	if o.DryRun != nil {
+		config.DryRun = *o.DryRun
 	}
-	if tries < 1 {
-		return fmt.Errorf("Exhausted retries: %v", err)
+	if o.CleanUpOrganizationProjects != nil {
+		config.CleanUpOrganizationProjects = *o.CleanUpOrganizationProjects
 	}
-	if isRetryableError(err) {
-		time.Sleep(duration)
-		tries--
-		// retry with exponential backoff
-		return retry(retryFunc, tries, 2*duration)
+	if o.TargetFolderId != nil || o.TargetFolders != nil {
+		config.RootFolderId = ""
+		config.TargetFolders = o.TargetFolders
+		if o.TargetFolderId != nil {
+			config.RootFolderId = *o.TargetFolderId
+		}
 	}
-	return err
-}
-
-func processProjectsResponsePage(removeProjectById func(projectId string)) func(page *cloudresourcemanager.ListProjectsResponse) error {
-	excludeProjectByOneOfLabelsFilter := func(project *cloudresourcemanager.Project) bool {
-		return !checkIfAtLeastOneLabelPresentIfAny(project, excludedLabelsMap, true)
+	if o.TargetFolderId != nil || o.TargetFolders != nil || o.CleanUpOrganizationProjects != nil {
+		errs = append(errs, config.validateTargets("target_folder_id", "target_folders", "clean_up_organization_projects")...)
 	}
-
-	includeProjectByOneOfLabelsFilter := func(project *cloudresourcemanager.Project) bool {
-		return checkIfAtLeastOneLabelPresentIfAny(project, includedLabelsMap, false)
+	if o.CleanUpTagKeys != nil {
+		config.CleanUpTagKeys = *o.CleanUpTagKeys
 	}
-
-	ageFilter := func(project *cloudresourcemanager.Project) bool {
-		projectCreatedAt, err := time.Parse(time.RFC3339, project.CreateTime)
-		if err != nil {
-			logger.Printf("Failed to parse CreateTime for [%s], skipping it, error [%s]", project.Name, err.Error())
-			return false
-		}
-		return projectCreatedAt.Before(resourceCreationCutoff)
+	if o.CleanUpSCCNotifications != nil {
+		config.CleanUpSCCNotifications = *o.CleanUpSCCNotifications
 	}
-
-	combinedProjectFilter := func(project *cloudresourcemanager.Project) bool {
-		return activeProjectFilter(project) && ageFilter(project) && includeProjectByOneOfLabelsFilter(project) && excludeProjectByOneOfLabelsFilter(project)
+	if o.CleanUpCaiFeeds != nil {
+		config.CleanUpCaiFeeds = *o.CleanUpCaiFeeds
 	}
-
-	return func(page *cloudresourcemanager.ListProjectsResponse) error {
-		for _, project := range page.Projects {
-			if combinedProjectFilter(project) {
-				projectId := project.ProjectId
-				removeProjectById(projectId)
-			}
+	if o.CleanUpBillingSinks != nil {
+		config.CleanUpBillingSinks = *o.CleanUpBillingSinks
+		if config.CleanUpBillingSinks && config.BillingAccount == "" {
+			errs = append(errs, fmt.Errorf("[clean_up_billing_sinks] requires [%s] to be set", BillingAccount))
 		}
-		return nil
 	}
+	if len(errs) > 0 {
+		return Config{}, fmt.Errorf("invalid Pub/Sub message payload: %w", errors.Join(errs...))
+	}
+	return config, nil
+}
+
+func activeProjectFilter(project *cloudresourcemanager.Project) bool {
+	return project.LifecycleState == LifecycleStateActiveRequested
 }
 
+// checkIfAtLeastOneLabelPresentIfAny reports whether the project has any of the labels, an empty
+// label set matches every project unless it is an exclusion list.
 func checkIfAtLeastOneLabelPresentIfAny(project *cloudresourcemanager.Project, labels map[string]string, isExcludeCheck bool) bool {
 	if len(labels) == 0 {
 		return !isExcludeCheck
@@ -181,6 +222,31 @@ func checkIfAtLeastOneLabelPresentIfAny(project *cloudresourcemanager.Project, l
 	return result
 }
 
+// parseLabelTime parses a time stored in a label value, e.g. expires-at. Label values can't hold
+// RFC 3339 timestamps, so it accepts Unix seconds, e.g. 1735689600, or a UTC date, e.g. 2025-01-01.
+func parseLabelTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	return time.Time{}, fmt.Errorf("[%s] is neither Unix seconds nor a YYYY-MM-DD date", value)
+}
+
+// findTag returns the first tag matching one of the namespaced tag keys or values, e.g.
+// "123/env" matches every value of the env key while "123/env/ci" only matches ci.
+func findTag(tags []*cloudresourcemanager3.EffectiveTag, namespacedTags []string) *cloudresourcemanager3.EffectiveTag {
+	for _, tag := range tags {
+		for _, namespaced := range namespacedTags {
+			if tag.NamespacedTagKey == namespaced || tag.NamespacedTagValue == namespaced {
+				return tag
+			}
+		}
+	}
+	return nil
+}
+
 func checkIfNameIncluded(name string, reg []*regexp.Regexp) bool {
 	if len(reg) == 0 {
 		return false
@@ -193,6 +259,21 @@ func checkIfNameIncluded(name string, reg []*regexp.Regexp) bool {
 	return false
 }
 
+// matchingProjectIdentifier returns the first of the project id, display name and number
+// matching one of the patterns, as "id [...]", "name [...]" or "number [...]".
+func matchingProjectIdentifier(project *cloudresourcemanager.Project, patterns []*regexp.Regexp) string {
+	for _, identifier := range []struct{ kind, value string }{
+		{"id", project.ProjectId},
+		{"name", project.Name},
+		{"number", strconv.FormatInt(project.ProjectNumber, 10)},
+	} {
+		if checkIfNameIncluded(identifier.value, patterns) {
+			return fmt.Sprintf("%s [%s]", identifier.kind, identifier.value)
+		}
+	}
+	return ""
+}
+
 func checkIfTagKeyShortNameExcluded(shortName string, excludedTagKeys []string) bool {
 	if len(excludedTagKeys) == 0 {
 		return false
@@ -205,609 +286,120 @@ func checkIfTagKeyShortNameExcluded(shortName string, excludedTagKeys []string)
 	return false
 }
 
-func getLabelsMapFromEnv(envVariableName string) map[string]string {
-	targetExcludedLabels := os.Getenv(envVariableName)
-	logger.Println("Try to get labels map")
-	labels := make(map[string]string)
-
-	if targetExcludedLabels == "" {
-		logger.Printf("No labels provided.")
-		return nil
-	}
-
-	err := json.Unmarshal([]byte(targetExcludedLabels), &labels)
-	if err != nil {
-		logger.Printf("Failed to get labels map from [%s] env variable, error [%s]", envVariableName, err.Error())
-	} else {
-		logger.Printf("Got labels map [%s] from [%s] env variable", labels, envVariableName)
-	}
-	return labels
-}
-
-func getRegexListFromEnv(envVariableName string) []*regexp.Regexp {
-	var compiledRegEx []*regexp.Regexp
-	envListVar := os.Getenv(envVariableName)
-	logger.Printf("Try to get [%s] list", envVariableName)
-	if envListVar == "" {
-		logger.Printf("No value for [%s] list provided.", envVariableName)
-		return compiledRegEx
-	}
-
-	var regexList []string
-	err := json.Unmarshal([]byte(envListVar), &regexList)
+// newGoogleClients initializes every API client used by the cleaner and returns all the
+// initialization errors at once.
+func newGoogleClients(ctx context.Context, config Config) (clients, error) {
+	logger.Println("Try to initialize Google clients")
+	client, err := google.DefaultClient(ctx, cloudresourcemanager.CloudPlatformScope)
 	if err != nil {
-		logger.Printf("Failed to get Regex list from [%s] env variable, error [%s]", envVariableName, err.Error())
-		return compiledRegEx
-	} else {
-		logger.Printf("Got Regex list [%s] from [%s] env variable", regexList, envVariableName)
+		return clients{}, fmt.Errorf("failed to initialize Google client, error [%s]", err.Error())
 	}
-
-	// build Regexes
-	for _, r := range regexList {
-		result, err := regexp.Compile(r)
+	httpClient := option.WithHTTPClient(client)
+	var errs []error
+	check := func(name string, err error) {
 		if err != nil {
-			logger.Printf("Invalid regular expression [%s] for [%s]", r, envVariableName)
-		} else {
-			compiledRegEx = append(compiledRegEx, result)
-		}
-	}
-	return compiledRegEx
-}
-
-func getTagKeysListFromEnv(envVariableName string) []string {
-	targetExcludedTagKeys := os.Getenv(envVariableName)
-	logger.Println("Try to get Tag Keys list")
-	if targetExcludedTagKeys == "" {
-		logger.Printf("No Tag Keys provided.")
-		return nil
-	}
-
-	var tagKeys []string
-	err := json.Unmarshal([]byte(targetExcludedTagKeys), &tagKeys)
-	if err != nil {
-		logger.Printf("Failed to get Tag Keys list from [%s] env variable, error [%s]", envVariableName, err.Error())
-	} else {
-		logger.Printf("Got Tag Keys list [%s] from [%s] env variable", tagKeys, envVariableName)
-	}
-	return tagKeys
-}
-
-func getBoolFromEnv(envVariableName string) bool {
-	envVariableNameVal, exists := os.LookupEnv(envVariableName)
-	if !exists {
-		logger.Fatalf("Environment variable [%s] not set, set the environment variable and try again.", envVariableName)
-	}
-	result, err := strconv.ParseBool(envVariableNameVal)
-	if err != nil {
-		logger.Fatalf("Invalid bool value [%s], specify correct value for environment variable [%s] and try again.", envVariableNameVal, envVariableName)
-	}
-	return result
-}
-
-func getIntFromEnv(envVariableName string) int64 {
-	envVariableStr := os.Getenv(envVariableName)
-	intValue, err := strconv.ParseInt(envVariableStr, 10, 0)
-	if err != nil {
-		logger.Fatalf("Could not convert [%s] to integer. Specify correct value for environment variable [%s] and try again.", envVariableStr, envVariableName)
-	}
-	return intValue
+			errs = append(errs, fmt.Errorf("failed to get %s client, error [%s]", name, err.Error()))
+		}
+	}
+
+	resourceManagerService, err := cloudresourcemanager.NewService(ctx, httpClient)
+	check("Cloud Resource Manager", err)
+	foldersService, err := cloudresourcemanager2.NewService(ctx, httpClient)
+	check("Folders", err)
+	tagsService, err := cloudresourcemanager3.NewService(ctx, httpClient)
+	check("Tags", err)
+	sccClient, err := securitycenter.NewClient(ctx)
+	check("SCC Notification", err)
+	assetClient, err := asset.NewClient(ctx)
+	check("Asset", err)
+	loggingService, err := logging.NewService(ctx, httpClient)
+	check("Logging Sink", err)
+	computeService, err := compute.NewService(ctx, httpClient)
+	check("Firewall Policies", err)
+	serviceManagementService, err := servicemanagement.NewService(ctx, httpClient)
+	check("Service Management", err)
+	containerClient, err := container.NewClusterManagerClient(ctx)
+	check("Container", err)
+
+	storageService, err := storage.NewService(ctx, httpClient)
+	check("Cloud Storage", err)
+	var reportSinks []reportSink
+	if config.ReportBucket != "" {
+		reportSinks = append(reportSinks, gcsReportSink{service: storageService, bucket: config.ReportBucket, prefix: config.ReportPrefix})
+	}
+	var checkpoints stateStore
+	if config.CheckpointBucket != "" {
+		checkpoints = gcsStateStore{service: storageService, bucket: config.CheckpointBucket, object: config.CheckpointObject}
+	}
+	if config.ReportTopic != "" {
+		pubSubService, err := pubsub.NewService(ctx, httpClient)
+		check("Pub/Sub", err)
+		reportSinks = append(reportSinks, pubSubReportSink{service: pubSubService, topic: config.ReportTopic})
+	}
+	if len(errs) > 0 {
+		return clients{}, errors.Join(errs...)
+	}
+	logger.Println("Initialized Google clients")
+
+	resourceManager := resourceManagerAdapter{service: resourceManagerService}
+	return clients{
+		projects:          resourceManager,
+		liens:             resourceManager,
+		folders:           foldersAdapter{service: foldersService.Folders, v3: tagsService.Folders, operations: tagsService.Operations},
+		tagKeys:           tagKeysAdapter{service: tagsService.TagKeys, operations: tagsService.Operations},
+		tagValues:         tagValuesAdapter{service: tagsService.TagValues, operations: tagsService.Operations},
+		effectiveTags:     effectiveTagsAdapter{service: tagsService.EffectiveTags},
+		sccNotifications:  sccNotificationsAdapter{client: sccClient},
+		feeds:             feedsAdapter{client: assetClient},
+		billingSinks:      billingSinksAdapter{service: loggingService.BillingAccounts.Sinks},
+		firewallPolicies:  firewallPoliciesAdapter{service: computeService.FirewallPolicies, operations: computeService.GlobalOrganizationOperations},
+		serviceManagement: serviceManagementAdapter{service: serviceManagementService},
+		clusters:          clustersAdapter{client: containerClient},
+		buckets:           bucketsAdapter{service: storageService.Buckets},
+		sharedVPC:         sharedVPCAdapter{service: computeService.Projects, operations: computeService.GlobalOperations},
+		reportSinks:       reportSinks,
+		notifiers:         newNotifiers(config),
+		stateStore:        checkpoints,
+	}, nil
 }
 
-func getCorrectFolderIdOrTerminateExecution() string {
-	targetFolderIdString := os.Getenv(TargetFolderId)
-	matched, err := regexp.MatchString(targetFolderRegexp, targetFolderIdString)
-	if err != nil || !matched {
-		logger.Fatalf("Invalid folder id [%s], specify correct value and try again.", targetFolderIdString)
-	}
-	return targetFolderIdString
-}
-
-func getBillingAccountOrTerminateExecution() string {
-	billingAccountVal := os.Getenv(BillingAccount)
-	if billingAccountVal == "" {
-		if cleanUpBillingSinks {
-			logger.Fatal("If billing account sink clean up is enabled, billing account id should not be empty, specify correct value and try again.")
+// invoke runs the clean up and returns the aggregated error of every failed step.
+func invoke(ctx context.Context, config Config) error {
+	if config.HostProjectId == "" && metadata.OnGCE() {
+		projectId, err := metadata.ProjectIDWithContext(ctx)
+		if err != nil {
+			logger.Errorf("Failed to get the host project from the metadata server, skipping the run, error [%s]", err.Error())
+			return err
 		}
-		return billingAccountVal
-	}
-	matched, err := regexp.MatchString(billingAccountRegex, billingAccountVal)
-	if err != nil || !matched {
-		logger.Fatalf("Invalid billing account id [%s], specify correct value and try again.", billingAccountVal)
-	}
-	return billingAccountVal
-}
-
-func getCorrectOrganizationIdOrTerminateExecution() string {
-	targetOrganizationIdString := os.Getenv(TargetOrganizationId)
-	matched, err := regexp.MatchString(targetOrganizationRegexp, targetOrganizationIdString)
-	if err != nil || !matched {
-		logger.Fatalf("Invalid organization id [%s], specify correct value and try again.", targetOrganizationIdString)
-	}
-	return targetOrganizationIdString
-}
-
-func getServiceManagementServiceOrTerminateExecution(ctx context.Context, client *http.Client) *servicemanagement.APIService {
-	service, err := servicemanagement.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		logger.Fatalf("Failed to get service management API client with error [%s], terminate execution", err.Error())
-	}
-	return service
-}
-
-func getResourceManagerServiceOrTerminateExecution(ctx context.Context, client *http.Client) *cloudresourcemanager.Service {
-	logger.Println("Try to get Cloud Resource Manager")
-	cloudResourceManagerService, err := cloudresourcemanager.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		logger.Fatalf("Failed to get Cloud Resource Manager with error [%s], terminate execution", err.Error())
-	}
-	logger.Println("Got Cloud Resource Manager")
-	return cloudResourceManagerService
-}
-
-func getFolderServiceOrTerminateExecution(ctx context.Context, client *http.Client) *cloudresourcemanager2.FoldersService {
-	logger.Println("Try to get Folders Service")
-	cloudResourceManagerService, err := cloudresourcemanager2.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		logger.Fatalf("Failed to get Folders Service with error [%s], terminate execution", err.Error())
-	}
-	logger.Println("Got Folders Service")
-	return cloudResourceManagerService.Folders
-}
-
-func getTagKeysServiceOrTerminateExecution(ctx context.Context, client *http.Client) *cloudresourcemanager3.TagKeysService {
-	logger.Println("Try to get TagKeys Service")
-	cloudResourceManagerService, err := cloudresourcemanager3.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		logger.Fatalf("Failed to get TagKeys Service with error [%s], terminate execution", err.Error())
+		config.HostProjectId = projectId
 	}
-	logger.Println("Got TagKeys Service")
-	return cloudResourceManagerService.TagKeys
-}
-
-func getTagValuesServiceOrTerminateExecution(ctx context.Context, client *http.Client) *cloudresourcemanager3.TagValuesService {
-	logger.Println("Try to get TagValues Service")
-	cloudResourceManagerService, err := cloudresourcemanager3.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		logger.Fatalf("Failed to get TagValues Service with error [%s], terminate execution", err.Error())
-	}
-	logger.Println("Got TagValues Service")
-	return cloudResourceManagerService.TagValues
-}
-
-func getBillingAccountSinkServiceOrTerminateExecution(ctx context.Context, client *http.Client) *logging.BillingAccountsSinksService {
-	loggingService, err := logging.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		logger.Fatalf("Failed to get Logging Sink Service with error [%s], terminate execution", err.Error())
-	}
-	logger.Println("Got Logging Sink Service")
-	return loggingService.BillingAccounts.Sinks
-}
-
-func getSCCNotificationServiceOrTerminateExecution(ctx context.Context) *securitycenter.Client {
-	logger.Println("Try to get SCC Notification Service")
-	securitycenterClient, err := securitycenter.NewClient(ctx)
-	if err != nil {
-		logger.Fatalf("Failed to get SCC Notification Service with error [%s], terminate execution", err.Error())
-	}
-	logger.Println("Got SCC Notification Service")
-	return securitycenterClient
-}
-
-func getAssetServiceOrTerminateExecution(ctx context.Context) *asset.Client {
-	logger.Println("Try to get Asset Service")
-	assetService, err := asset.NewClient(ctx)
-	if err != nil {
-		logger.Fatalf("Failed to get Asset Service with error [%s], terminate execution", err.Error())
-	}
-	logger.Println("Got Asset Service")
-	return assetService
-}
-
-func getContainerServiceOrTerminateExecution(ctx context.Context) *container.ClusterManagerClient {
-	logger.Println("Try to get Container Service")
-	containerService, err := container.NewClusterManagerClient(ctx)
+	c, err := newGoogleClients(ctx, config)
 	if err != nil {
-		logger.Fatalf("Failed to get Container Service with error [%s], terminate execution", err.Error())
+		logger.Errorf("Failed to initialize Google clients, skipping the run, error [%s]", err.Error())
+		return err
 	}
-	logger.Println("Got Container Service")
-	return containerService
+	return newCleaner(c, config, time.Now()).run(ctx).err()
 }
 
-func getFirewallPoliciesServiceOrTerminateExecution(ctx context.Context, client *http.Client) *compute.FirewallPoliciesService {
-	logger.Println("Try to get Firewall Policies Service")
-	computeService, err := compute.NewService(ctx, option.WithHTTPClient(client))
+func CleanUpProjects(ctx context.Context, m PubSubMessage) error {
+	options, err := getInvocationOptions(m)
 	if err != nil {
-		logger.Fatalf("Failed to get Firewall Policies Service with error [%s], terminate execution", err.Error())
+		logger.Errorf("Skipping the run, %s", err.Error())
+		return err
 	}
-	logger.Println("Got Firewall Policies Service")
-	return computeService.FirewallPolicies
-}
-
-func initializeGoogleClient(ctx context.Context) *http.Client {
-	logger.Println("Try to initialize Google client")
-	client, err := google.DefaultClient(ctx, cloudresourcemanager.CloudPlatformScope)
+	config, err := LoadConfigFromEnv()
 	if err != nil {
-		logger.Fatalf("Failed to initialize Google client with error [%s], terminate execution", err.Error())
-	}
-	logger.Println("Initialized Google client")
-	return client
-}
-
-func invoke(ctx context.Context) {
-	client := initializeGoogleClient(ctx)
-	cloudResourceManagerService := getResourceManagerServiceOrTerminateExecution(ctx, client)
-	folderService := getFolderServiceOrTerminateExecution(ctx, client)
-	tagKeyService := getTagKeysServiceOrTerminateExecution(ctx, client)
-	sccService := getSCCNotificationServiceOrTerminateExecution(ctx)
-	tagValuesService := getTagValuesServiceOrTerminateExecution(ctx, client)
-	feedsService := getAssetServiceOrTerminateExecution(ctx)
-	billingSinkService := getBillingAccountSinkServiceOrTerminateExecution(ctx, client)
-	firewallPoliciesService := getFirewallPoliciesServiceOrTerminateExecution(ctx, client)
-	endpointService := getServiceManagementServiceOrTerminateExecution(ctx, client)
-	containerService := getContainerServiceOrTerminateExecution(ctx)
-
-	removeLien := func(name string) {
-		logger.Printf("Try to remove lien [%s]", name)
-		_, err := cloudResourceManagerService.Liens.Delete(name).Context(ctx).Do()
-		if err != nil {
-			logger.Printf("Failed to remove lien [%s], error [%s]", name, err.Error())
-		} else {
-			logger.Printf("Removed lien [%s]", name)
-		}
-	}
-
-	tagKeyAgeFilter := func(tagKey *cloudresourcemanager3.TagKey) bool {
-		tagKeyCreatedAt, err := time.Parse(time.RFC3339, tagKey.CreateTime)
-		if err != nil {
-			logger.Printf("Failed to parse CreateTime for tagKey [%s], skipping it, error [%s]", tagKey.Name, err.Error())
-			return false
-		}
-		return tagKeyCreatedAt.Before(resourceCreationCutoff)
-	}
-
-	billingSinkAgeFilter := func(logSink *logging.LogSink) bool {
-		createdAt, err := time.Parse(time.RFC3339, logSink.CreateTime)
-		if err != nil {
-			logger.Printf("Failed to parse CreateTime for tagKey [%s], skipping it, error [%s]", logSink.ResourceName, err.Error())
-			return false
-		}
-		return createdAt.Before(resourceCreationCutoff)
-	}
-
-	projectDeleteRequestedFilter := func(projectID string) bool {
-		p, err := cloudResourceManagerService.Projects.Get(projectID).Context(ctx).Do()
-		if err != nil {
-			logger.Printf("Failed to get project [%s], error [%s]", projectID, err.Error())
-			return false
-		}
-		if p.LifecycleState == "DELETE_REQUESTED" {
-			return true
-		}
-		return false
-	}
-
-	removeSCCNotifications := func(organization string) {
-		logger.Printf("Try to remove SCC Notifications from organization [%s]", organization)
-		req := &securitycenterpb.ListNotificationConfigsRequest{
-			Parent:   fmt.Sprintf("organizations/%s", organization),
-			PageSize: sccPageSize,
-		}
-		it := sccService.ListNotificationConfigs(ctx, req)
-		for {
-			resp, err := it.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				logger.Printf("failed to list SCC notifications, error [%s]", err.Error())
-				break
-			}
-			projectID := strings.Split(resp.PubsubTopic, "/")[1]
-			if checkIfNameIncluded(resp.Name, includedSCCNotfisList) && projectDeleteRequestedFilter(projectID) {
-				delReq := &securitycenterpb.DeleteNotificationConfigRequest{
-					Name: resp.Name,
-				}
-				err = sccService.DeleteNotificationConfig(ctx, delReq)
-				if err != nil {
-					logger.Printf("failed to delete SCC notification [%s], error [%s]", resp.Name, err.Error())
-				} else {
-					logger.Printf("SCC notification [%s] deleted", resp.Name)
-				}
-			}
-		}
-	}
-
-	removeTagValues := func(tagKey string) {
-		logger.Printf("Try to remove Tag Values from TagKey [%s]", tagKey)
-		tagValuesList, err := tagValuesService.List().Parent(tagKey).Context(ctx).Do()
-		if err != nil {
-			logger.Printf("Failed to list Tag values from TagKey [%s], error [%s]", tagKey, err.Error())
-			return
-		}
-		for _, tagValue := range tagValuesList.TagValues {
-			_, err := tagValuesService.Delete(tagValue.Name).Context(ctx).Do()
-			if err != nil {
-				logger.Printf("Failed to delete tagValue from TagKey [%s], error [%s]", tagKey, err.Error())
-			}
-		}
-	}
-
-	removeTagKeys := func(organization string) {
-		logger.Printf("Try to remove Tag Keys from organization [%s]", organization)
-		parent := fmt.Sprintf("organizations/%s", organization)
-		tagKeysList, err := tagKeyService.List().Parent(parent).Context(ctx).Do()
-		if err != nil {
-			logger.Printf("Failed to list Tag Keys from organization [%s], error [%s]", organization, err.Error())
-			return
-		}
-		for _, tagKey := range tagKeysList.TagKeys {
-			if !checkIfTagKeyShortNameExcluded(tagKey.ShortName, excludedTagKeysList) && tagKeyAgeFilter(tagKey) {
-				removeTagValues(tagKey.Name)
-				_, err := tagKeyService.Delete(tagKey.Name).Context(ctx).Do()
-				if err != nil {
-					logger.Printf("Failed to delete tagKey from organization [%s], error [%s]", organization, err.Error())
-				}
-			}
-		}
-	}
-
-	removeFeedsByName := func(organization string) {
-		logger.Printf("Try to remove feeds from organization [%s]", organization)
-
-		req := &assetpb.ListFeedsRequest{
-			Parent: fmt.Sprintf("organizations/%s", organization),
-		}
-
-		resp, err := feedsService.ListFeeds(ctx, req)
-		if err != nil {
-			logger.Printf("Failed to list Feeds, error [%s]", err.Error())
-			return
-		}
-
-		for _, feed := range resp.Feeds {
-			projectID := strings.Split(feed.FeedOutputConfig.GetPubsubDestination().Topic, "/")[1]
-			if checkIfNameIncluded(feed.Name, includedFeedsList) && projectDeleteRequestedFilter(projectID) {
-				delReq := &assetpb.DeleteFeedRequest{
-					Name: feed.Name,
-				}
-				err := feedsService.DeleteFeed(ctx, delReq)
-				if err != nil {
-					logger.Printf("Failed to remove the feed [%s], error [%s]", feed.Name, err.Error())
-				} else {
-					logger.Printf("Feed [%s] successfully removed.", feed.Name)
-				}
-			}
-		}
-	}
-
-	removeBillingSinks := func(billing string) {
-		logger.Printf("Try to remove billing account log sinks from billing account [%s]", billing)
-		parent := fmt.Sprintf("billingAccounts/%s", billing)
-		sinkList, err := billingSinkService.List(parent).PageSize(billingSinksPageSize).Context(ctx).Do()
-		if err != nil {
-			logger.Printf("Failed to list billing account log sinks from billing account [%s], error [%s]", billing, err.Error())
-			return
-		}
-		for _, sink := range sinkList.Sinks {
-			if sink.Name != "_Required" && sink.Name != "_Default" && billingSinkAgeFilter(sink) && checkIfNameIncluded(sink.ResourceName, targetBillingSinks) {
-				_, err = billingSinkService.Delete(sink.ResourceName).Context(ctx).Do()
-				if err != nil {
-					logger.Printf("Failed to delete billing account log sink [%s] from billing account [%s], error [%s]", sink.ResourceName, billing, err.Error())
-				}
-			}
-		}
-	}
-
-	removeFirewallPolicies := func(folder string) {
-		logger.Printf("Try to remove Firewall Policies from folder [%s]", folder)
-		firewallPolicyList, err := firewallPoliciesService.List().ParentId(folder).Context(ctx).Do()
-		if err != nil {
-			logger.Printf("Failed to list Firewall Policies from folder [%s], error [%s]", folder, err.Error())
-			return
-		}
-		for _, policy := range firewallPolicyList.Items {
-			for _, association := range policy.Associations {
-				_, err := firewallPoliciesService.RemoveAssociation(policy.Name).Name(association.Name).Context(ctx).Do()
-				if err != nil {
-					logger.Printf("Failed to Remove Association for Firewall Policies from folder [%s], error [%s]", folder, err.Error())
-				}
-			}
-			_, err := firewallPoliciesService.Delete(policy.Name).Context(ctx).Do()
-			if err != nil {
-				logger.Printf("Failed to delete Firewall Policy [%s] from folder [%s], error [%s]", policy.Name, folder, err.Error())
-			}
-		}
-	}
-
-	removeProjectById := func(projectId string) error {
-		_, err := cloudResourceManagerService.Projects.Delete(projectId).Context(ctx).Do()
+		logger.Errorf("Skipping the run, %s", err.Error())
 		return err
 	}
-
-	removeProjectClusters := func(projectId string) int {
-		logger.Printf("Try to remove clusters for [%s]", projectId)
-		reqLCR := &containerpb.ListClustersRequest{Parent: fmt.Sprintf("projects/%s/locations/-", projectId)}
-		listResponse, err := containerService.ListClusters(ctx, reqLCR)
-		if err != nil {
-			logger.Printf("Failed to list clusters for [%s], error [%s]", projectId, err.Error())
-			return 0
-		}
-
-		logger.Printf("Got [%d] clusters for project [%s]", len(listResponse.Clusters), projectId)
-		if len(listResponse.Clusters) == 0 {
-			return 0
-		}
-
-		var pendingDeletion int = 0
-		for _, cluster := range listResponse.Clusters {
-			switch clusterStatus := cluster.Status.String(); clusterStatus {
-			case "DEGRADED":
-				fallthrough
-			case "RUNNING":
-				logger.Printf("Deleting cluster %s status: %s", cluster.Name, clusterStatus)
-				reqDCR := &containerpb.DeleteClusterRequest{Name: fmt.Sprintf("projects/%s/locations/%s/clusters/%s", projectId, cluster.Location, cluster.Name)}
-				_, err := containerService.DeleteCluster(ctx, reqDCR)
-				if err != nil {
-					logger.Printf("Failed to delete cluster [%s] for [%s], error [%s]", cluster.Name, projectId, err.Error())
-				} else {
-					pendingDeletion++
-				}
-			case "PROVISIONING":
-				fallthrough
-			case "RECONCILING":
-				fallthrough
-			case "STOPPING":
-				logger.Printf("Deferring cluster %s status: %s", cluster.Name, clusterStatus)
-				pendingDeletion++
-			default:
-				logger.Printf("Ignoring cluster %s status: %s", cluster.Name, clusterStatus)
-			}
-		}
-		return pendingDeletion
-	}
-
-	removeProjectEndpoints := func(projectId string) {
-		logger.Printf("Try to remove endpoints for [%s]", projectId)
-		listResponse, err := endpointService.Services.List().ProducerProjectId(projectId).Do()
-		if err != nil {
-			logger.Printf("Failed to list services for [%s], error [%s]", projectId, err.Error())
-			return
-		}
-
-		logger.Printf("Got [%d] services for the project [%s]", len(listResponse.Services), projectId)
-		if len(listResponse.Services) == 0 {
-			return
-		}
-
-		for _, service := range listResponse.Services {
-			logger.Printf("Try to remove service: %s", service.ServiceName)
-			_, err = endpointService.Services.Delete(service.ServiceName).Do()
-			if err != nil {
-				logger.Printf("Failed to delete service [%s] for [%s], error [%s]", service.ServiceName, projectId, err.Error())
-			}
-		}
-
-		// wait for services to complete deletion
-		time.Sleep(10 * time.Second)
-	}
-
-	cleanupProjectById := func(projectId string) {
-		logger.Printf("Try to remove project [%s]", projectId)
-		if clusters := removeProjectClusters(projectId); clusters != 0 {
-			logger.Printf("Defer removing project [%s], %d clusters marked for deletion", projectId, clusters)
-			return
-		}
-		err := removeProjectById(projectId)
-		if err != nil {
-			removeProjectEndpoints(projectId)
-			err = removeProjectById(projectId)
-		}
-		if err != nil {
-			logger.Printf("Failed to remove project [%s], error [%s]", projectId, err.Error())
-		} else {
-			logger.Printf("Removed project [%s]", projectId)
-		}
-	}
-
-	removeProjectWithLiens := func(projectId string) {
-		logger.Printf("Try to get all liens for the project [%s]", projectId)
-		parent := fmt.Sprintf("projects/%s", projectId)
-		req := cloudResourceManagerService.Liens.List().Parent(parent)
-		if err := req.Pages(ctx, func(page *cloudresourcemanager.ListLiensResponse) error {
-			logger.Printf("Got [%d] liens for the project [%s]", len(page.Liens), projectId)
-			for _, lien := range page.Liens {
-				removeLien(lien.Name)
-			}
-			cleanupProjectById(projectId)
-			return nil
-		}); err != nil {
-			logger.Printf("Failed to get all liens for the project [%s], error [%s]", projectId, err.Error())
-		}
-	}
-
-	removeProjectsInFolder := func(folderId string) {
-		localFolderId := strings.Replace(folderId, "folders/", "", 1)
-		logger.Printf("Try to get projects from folder with id [%s] and process them", localFolderId)
-		requestFilter := fmt.Sprintf("parent.type:folder parent.id:%s", localFolderId)
-		err := retry(func() (err error) {
-			req := cloudResourceManagerService.Projects.List().Filter(requestFilter)
-			err = req.Pages(ctx, processProjectsResponsePage(removeProjectWithLiens))
-			return
-		}, 5, time.Minute)
-		if err != nil {
-			logger.Printf("Failed to get projects for the folder with id [%s], error [%s]", localFolderId, err.Error())
-		} else {
-			logger.Printf("Got and processed all projects for the folder with id [%s]", localFolderId)
-		}
-	}
-
-	folderAgeFilter := func(folder *cloudresourcemanager2.Folder) bool {
-		folderCreatedAt, err := time.Parse(time.RFC3339, folder.CreateTime)
-		if err != nil {
-			logger.Printf("Failed to parse CreateTime for folder [%s], skipping it, error [%s]", folder.Name, err.Error())
-			return false
-		}
-		return folderCreatedAt.Before(resourceCreationCutoff)
-	}
-
-	removeFolder := func(folder *cloudresourcemanager2.Folder) {
-		folderId := folder.Name
-		removeFirewallPolicies(folderId)
-		logger.Printf("Try to delete folder with id [%s]", folderId)
-		_, err := folderService.Delete(folderId).Do()
-		if err != nil {
-			logger.Printf("Failed to delete folder [%s], error [%s]", folderId, err.Error())
-		} else {
-			logger.Printf("Deleted folder [%s]", folderId)
-		}
-	}
-
-	getSubFoldersAndRemoveProjectsFoldersRecursively := func(folder *cloudresourcemanager2.Folder, recursion FolderRecursion) {
-		folderId := folder.Name
-		listFoldersRequest := folderService.List().Parent(folderId).ShowDeleted(false)
-		if err := listFoldersRequest.Pages(ctx, func(foldersResponse *cloudresourcemanager2.ListFoldersResponse) error {
-			for _, folder := range foldersResponse.Folders {
-				recursion(folder, recursion)
-			}
-			removeProjectsInFolder(folderId)
-			if folder.Parent != fmt.Sprintf("folders/%s", rootFolderId) && folder.Name != fmt.Sprintf("folders/%s", rootFolderId) && folderAgeFilter(folder) {
-				removeFolder(folder)
-			}
-			return nil
-		}); err != nil {
-			logger.Fatalf("Failed to get subfolders for the folder with id [%s], error [%s]", folderId, err.Error())
-		}
-	}
-
-	rootFolderId := fmt.Sprintf("folders/%s", rootFolderId)
-	rootFolder, err := folderService.Get(rootFolderId).Do()
+	config, err = options.apply(config)
 	if err != nil {
-		logger.Printf("Failed to get parent folder [%s], error [%s]", rootFolderId, err.Error())
-	} else {
-		getSubFoldersAndRemoveProjectsFoldersRecursively(rootFolder, getSubFoldersAndRemoveProjectsFoldersRecursively)
-	}
-
-	// Only Tag Keys whose values are not in use can be deleted.
-	if cleanUpTagKeys {
-		removeTagKeys(organizationId)
-	}
-
-	// only delete Security Command Center notifications from deleted projects
-	if cleanUpSCCNotfi {
-		removeSCCNotifications(organizationId)
-	}
-
-	// Only delete Feeds from deleted projects
-	if cleanUpCaiFeeds {
-		removeFeedsByName(organizationId)
+		logger.Errorf("Skipping the run, %s", err.Error())
+		return err
 	}
-
-	if cleanUpBillingSinks {
-		removeBillingSinks(billingAccount)
+	err = invoke(ctx, config)
+	if err != nil && config.ReturnErrorOnFailure {
+		return err
 	}
-}
-
-func CleanUpProjects(ctx context.Context, m PubSubMessage) error {
-	invoke(ctx)
 	return nil
 }
@@ -0,0 +1,267 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetInvocationOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		data    string
+		dryRun  *bool
+		wantErr bool
+	}{
+		{name: "empty", data: ""},
+		{name: "default scheduler message", data: "test"},
+		{name: "dry run", data: `{"dry_run": true}`, dryRun: boolPtr(true)},
+		{name: "invalid JSON object", data: `{"dry_run": tru`, wantErr: true},
+		{name: "unknown option", data: `{"dryrun": true}`, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			options, err := getInvocationOptions(PubSubMessage{Data: []byte(tc.data)})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+			if (options.DryRun == nil) != (tc.dryRun == nil) || (options.DryRun != nil && *options.DryRun != *tc.dryRun) {
+				t.Errorf("got dry run %v, want %v", options.DryRun, tc.dryRun)
+			}
+		})
+	}
+}
+
+func TestInvocationOptionsApply(t *testing.T) {
+	options, err := getInvocationOptions(PubSubMessage{Data: []byte(`{
+		"target_folder_id": "300",
+		"max_project_age_hours": 168,
+		"target_included_labels": {"env": "sandbox"},
+		"target_excluded_labels": {},
+		"target_excluded_projects": ["^shared-"],
+		"clean_up_tag_keys": false
+	}`)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	config := testConfig()
+	config.ExcludedLabels = map[string]string{"keep": "true"}
+	config.CleanUpTagKeys = true
+	config.CleanUpCaiFeeds = true
+
+	config, err = options.apply(config)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.RootFolderId != "300" || config.MaxProjectAgeHours != 168 {
+		t.Errorf("target folder and max age were not overridden, got %+v", config)
+	}
+	if config.IncludedLabels["env"] != "sandbox" || len(config.ExcludedLabels) != 0 {
+		t.Errorf("labels were not overridden, got %+v", config)
+	}
+	if len(config.ExcludedProjects) != 1 || !config.ExcludedProjects[0].MatchString("shared-ci") {
+		t.Errorf("excluded projects were not overridden, got %+v", config)
+	}
+	if config.CleanUpTagKeys || !config.CleanUpCaiFeeds {
+		t.Errorf("only the provided steps should be overridden, got %+v", config)
+	}
+}
+
+func TestInvocationOptionsApplyTargetFolders(t *testing.T) {
+	options, err := getInvocationOptions(PubSubMessage{Data: []byte(`{"target_folders": [{"folder_id": "300", "max_project_age_hours": 1}]}`)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	config, err := options.apply(testConfig())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.RootFolderId != "" || len(config.targets()) != 1 || config.targets()[0].FolderId != "300" {
+		t.Errorf("target folders should replace the configured folder, got %+v", config)
+	}
+
+	options, err = getInvocationOptions(PubSubMessage{Data: []byte(`{"target_folders": [], "clean_up_organization_projects": false}`)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := options.apply(testConfig()); err == nil || !strings.Contains(err.Error(), "target_folders") {
+		t.Errorf("expected an error about the missing targets, got %v", err)
+	}
+}
+
+func TestInvocationOptionsApplyRejectsInvalidValues(t *testing.T) {
+	options, err := getInvocationOptions(PubSubMessage{Data: []byte(`{"target_folder_id": "folders/300", "max_project_age_hours": -1, "target_included_projects": ["ci-("], "clean_up_billing_sinks": true}`)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = options.apply(testConfig())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"target_folder_id", "max_project_age_hours", "target_included_projects", "clean_up_billing_sinks"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err.Error(), want)
+		}
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func setValidConfigEnv(t *testing.T) {
+	for name, value := range map[string]string{
+		TargetOrganizationId:     "1",
+		TargetFolderId:           "100",
+		MaxProjectAgeHours:       "24",
+		TargetIncludedLabels:     `{"env":"ci"}`,
+		TargetExcludedLabels:     "",
+		CleanUpTagKeys:           "true",
+		TargetExcludedTagKeys:    `["keep"]`,
+		CleanUpSCCNotfi:          "false",
+		TargetIncludedSCCNotfis:  `["scc-.*"]`,
+		SCCNotificationsPageSize: "500",
+		CleanUpCaiFeeds:          "false",
+		TargetIncludedFeeds:      "",
+		BillingAccount:           "",
+		CleanUpBillingSinks:      "false",
+		TargetBillingSinks:       "",
+		BillingSinksPageSize:     "200",
+		DryRun:                   "",
+		ReportPubSubTopic:        "projects/p/topics/reports",
+	} {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	setValidConfigEnv(t)
+	config, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.RootFolderId != "100" || config.MaxProjectAgeHours != 24 || config.SCCPageSize != 500 {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if config.IncludedLabels["env"] != "ci" || len(config.ExcludedTagKeys) != 1 || len(config.IncludedSCCNotifications) != 1 {
+		t.Errorf("unexpected filters in config %+v", config)
+	}
+	if config.DryRun || config.ReturnErrorOnFailure {
+		t.Errorf("optional flags should default to false, got %+v", config)
+	}
+	if config.FolderDeletionMode != folderDeletionAlways || config.ProtectedFolderDepth != 1 {
+		t.Errorf("folder deletion should default to always with the root's direct children protected, got %+v", config)
+	}
+	if config.TagKeysPageSize != 300 || config.TagValuesPageSize != 300 || config.FirewallPoliciesPageSize != 500 || config.EndpointsServicesPageSize != 100 {
+		t.Errorf("page sizes should default to the API maximums, got %+v", config)
+	}
+}
+
+func TestLoadConfigFromEnvNotifications(t *testing.T) {
+	setValidConfigEnv(t)
+	t.Setenv(NotifySlackWebhookURL, "https://hooks.slack.com/services/T/B/X")
+	t.Setenv(NotifyGoogleChatWebhookURL, "https://chat.googleapis.com/v1/spaces/S/messages")
+	t.Setenv(NotifyWebhookURL, "https://example.com/hook")
+	t.Setenv(NotifySMTPAddress, "smtp.example.com:587")
+	t.Setenv(NotifySMTPFrom, "cleaner@example.com")
+	t.Setenv(NotifySMTPTo, `["team@example.com", "ops@example.com"]`)
+	t.Setenv(NotifySMTPUsername, "cleaner")
+	t.Setenv(NotifySMTPPassword, "secret")
+	config, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if config.SlackWebhookURL == "" || config.GoogleChatWebhookURL == "" || config.WebhookURL != "https://example.com/hook" {
+		t.Errorf("unexpected webhooks in config %+v", config)
+	}
+	if config.SMTPAddress != "smtp.example.com:587" || config.SMTPFrom != "cleaner@example.com" || len(config.SMTPTo) != 2 || config.SMTPUsername != "cleaner" || config.SMTPPassword != "secret" {
+		t.Errorf("unexpected SMTP settings in config %+v", config)
+	}
+	if notifiers := newNotifiers(config); len(notifiers) != 4 {
+		t.Errorf("expected a notifier per destination, got %d", len(notifiers))
+	}
+
+	t.Setenv(NotifySlackWebhookURL, "hooks.slack.com")
+	t.Setenv(NotifySMTPAddress, "smtp.example.com")
+	t.Setenv(NotifySMTPTo, "")
+	_, err = LoadConfigFromEnv()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{NotifySlackWebhookURL, NotifySMTPAddress} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err.Error(), want)
+		}
+	}
+}
+
+func TestLoadConfigFromEnvTargets(t *testing.T) {
+	setValidConfigEnv(t)
+	t.Setenv(TargetFolderId, "")
+	t.Setenv(TargetFolders, `[{"folder_id": "200"}, {"folder_id": "300", "max_project_age_hours": 1, "target_included_labels": {"env": "pr"}}]`)
+	config, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	targets := config.targets()
+	if len(targets) != 2 || targets[1].FolderId != "300" {
+		t.Fatalf("unexpected targets %+v", targets)
+	}
+	target := config.forTarget(targets[1])
+	if target.RootFolderId != "300" || target.MaxProjectAgeHours != 1 || target.IncludedLabels["env"] != "pr" || len(target.ExcludedTagKeys) != 1 {
+		t.Errorf("target folder filters should override the configured ones, got %+v", target)
+	}
+
+	t.Setenv(TargetFolders, `[{"folder_id": "folders/300", "target_included_tags": ["env"]}]`)
+	if _, err := LoadConfigFromEnv(); err == nil || !strings.Contains(err.Error(), "folders/300") || !strings.Contains(err.Error(), "target_included_tags") {
+		t.Errorf("expected an error about the invalid target folder, got %v", err)
+	}
+
+	t.Setenv(TargetFolders, "")
+	if _, err := LoadConfigFromEnv(); err == nil || !strings.Contains(err.Error(), CleanUpOrganizationProjects) {
+		t.Errorf("expected an error about the missing targets, got %v", err)
+	}
+
+	t.Setenv(CleanUpOrganizationProjects, "true")
+	if config, err := LoadConfigFromEnv(); err != nil || !config.CleanUpOrganizationProjects {
+		t.Errorf("organization projects alone should be a valid target, got %+v, %v", config, err)
+	}
+}
+
+func TestLoadConfigFromEnvReportsEveryProblem(t *testing.T) {
+	setValidConfigEnv(t)
+	t.Setenv(TargetFolderId, "folders/100")
+	t.Setenv(TargetIncludedLabels, `{"env":`)
+	t.Setenv(TargetIncludedSCCNotfis, `["scc-(.*"]`)
+	t.Setenv(SCCNotificationsPageSize, "5000")
+	t.Setenv(CleanUpBillingSinks, "true")
+	t.Setenv(DryRun, "maybe")
+	t.Setenv(TargetExcludedLabelSelector, "env in ci")
+	t.Setenv(FolderDeletionMode, "sometimes")
+	t.Setenv(TagKeysPageSize, "0")
+	t.Setenv(ProtectedResources, `["buckets/logs"]`)
+
+	_, err := LoadConfigFromEnv()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{TargetFolderId, TargetIncludedLabels, TargetIncludedSCCNotfis, SCCNotificationsPageSize, BillingAccount, DryRun, TargetExcludedLabelSelector, FolderDeletionMode, TagKeysPageSize, ProtectedResources} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err.Error(), want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxNotificationEvents caps the number of project events listed in text notifications.
+const maxNotificationEvents = 50
+
+// notifier sends the outcome of a run to the people responsible for the cleaned up projects.
+type notifier interface {
+	Notify(ctx context.Context, n notification) error
+}
+
+// notification is the outcome of a run handed to every notifier.
+type notification struct {
+	RunID   string         `json:"run_id"`
+	DryRun  bool           `json:"dry_run"`
+	Summary string         `json:"summary"`
+	Errors  []string       `json:"errors,omitempty"`
+	Events  []projectEvent `json:"events,omitempty"`
+	Report  *runReport     `json:"report"`
+}
+
+// projectEvent is a single project the run did something about.
+type projectEvent struct {
+	ProjectId string `json:"project_id"`
+	Action    string `json:"action"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// newNotification collects the project events of the report, skipped projects are left out.
+func newNotification(report *runReport) notification {
+	n := notification{
+		RunID:   report.RunID,
+		DryRun:  report.DryRun,
+		Summary: report.summary(),
+		Errors:  report.Errors,
+		Report:  report,
+	}
+	projects, ok := report.Resources[resourceProject]
+	if !ok {
+		return n
+	}
+	for _, name := range projects.Deleted {
+		n.Events = append(n.Events, projectEvent{ProjectId: name, Action: "deleted"})
+	}
+	for _, name := range projects.Planned {
+		n.Events = append(n.Events, projectEvent{ProjectId: name, Action: "planned", Reason: "dry run"})
+	}
+	for _, item := range projects.Scheduled {
+		n.Events = append(n.Events, projectEvent{ProjectId: item.Name, Action: "scheduled", Reason: item.Reason})
+	}
+	for _, item := range projects.Deferred {
+		n.Events = append(n.Events, projectEvent{ProjectId: item.Name, Action: "deferred", Reason: item.Reason})
+	}
+	for _, item := range projects.Blocked {
+		n.Events = append(n.Events, projectEvent{ProjectId: item.Name, Action: "blocked", Reason: item.Reason})
+	}
+	for _, item := range projects.Failed {
+		n.Events = append(n.Events, projectEvent{ProjectId: item.Name, Action: "failed", Error: item.Error})
+	}
+	return n
+}
+
+// isEmpty reports whether the run did nothing worth notifying about.
+func (n notification) isEmpty() bool {
+	return len(n.Events) == 0 && len(n.Errors) == 0
+}
+
+// text renders the notification as a short plain text message.
+func (n notification) text() string {
+	var b strings.Builder
+	dryRun := ""
+	if n.DryRun {
+		dryRun = " (dry run)"
+	}
+	fmt.Fprintf(&b, "Project clean up run %s%s finished: %s, %d errors", n.RunID, dryRun, n.Summary, len(n.Errors))
+	for i, event := range n.Events {
+		if i == maxNotificationEvents {
+			fmt.Fprintf(&b, "\n... and %d more projects", len(n.Events)-maxNotificationEvents)
+			break
+		}
+		fmt.Fprintf(&b, "\n- %s %s", event.Action, event.ProjectId)
+		if event.Reason != "" {
+			fmt.Fprintf(&b, ", %s", event.Reason)
+		}
+		if event.Error != "" {
+			fmt.Fprintf(&b, ", error [%s]", event.Error)
+		}
+	}
+	return b.String()
+}
+
+// notifierTimeout bounds every notification, so that an unresponsive destination can't hold
+// the end of the run.
+const notifierTimeout = 30 * time.Second
+
+var notifierHTTPClient = &http.Client{Timeout: notifierTimeout}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("got HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// slackNotifier posts the text notification to a Slack incoming webhook.
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (s slackNotifier) Notify(ctx context.Context, n notification) error {
+	if err := postJSON(ctx, s.client, s.url, map[string]string{"text": n.text()}); err != nil {
+		return fmt.Errorf("failed to notify Slack, error [%s]", err.Error())
+	}
+	return nil
+}
+
+// googleChatNotifier posts the text notification to a Google Chat space webhook.
+type googleChatNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (s googleChatNotifier) Notify(ctx context.Context, n notification) error {
+	if err := postJSON(ctx, s.client, s.url, map[string]string{"text": n.text()}); err != nil {
+		return fmt.Errorf("failed to notify Google Chat, error [%s]", err.Error())
+	}
+	return nil
+}
+
+// webhookNotifier posts the whole notification, including the run report, as JSON.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (s webhookNotifier) Notify(ctx context.Context, n notification) error {
+	if err := postJSON(ctx, s.client, s.url, n); err != nil {
+		return fmt.Errorf("failed to notify webhook, error [%s]", err.Error())
+	}
+	return nil
+}
+
+// smtpNotifier sends the text notification by email.
+type smtpNotifier struct {
+	address string
+	from    string
+	to      []string
+	auth    smtp.Auth
+	// timeout bounds the whole SMTP session, notifierTimeout if zero.
+	timeout time.Duration
+}
+
+func (s smtpNotifier) Notify(ctx context.Context, n notification) error {
+	text := n.text()
+	subject, _, _ := strings.Cut(text, "\n")
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		s.from, strings.Join(s.to, ", "), subject, strings.ReplaceAll(text, "\n", "\r\n"))
+	if err := s.send(ctx, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send notification email through [%s], error [%s]", s.address, err.Error())
+	}
+	return nil
+}
+
+// send is smtp.SendMail with a deadline on the connection, which smtp.SendMail lacks.
+func (s smtpNotifier) send(ctx context.Context, message []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(s.timeout, notifierTimeout))
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	host, _, _ := net.SplitHostPort(s.address)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if err := client.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// newNotifiers returns a notifier for every destination set in config.
+func newNotifiers(config Config) []notifier {
+	var notifiers []notifier
+	if config.SlackWebhookURL != "" {
+		notifiers = append(notifiers, slackNotifier{url: config.SlackWebhookURL, client: notifierHTTPClient})
+	}
+	if config.GoogleChatWebhookURL != "" {
+		notifiers = append(notifiers, googleChatNotifier{url: config.GoogleChatWebhookURL, client: notifierHTTPClient})
+	}
+	if config.WebhookURL != "" {
+		notifiers = append(notifiers, webhookNotifier{url: config.WebhookURL, client: notifierHTTPClient})
+	}
+	if config.SMTPAddress != "" {
+		var auth smtp.Auth
+		if config.SMTPUsername != "" {
+			host, _, _ := strings.Cut(config.SMTPAddress, ":")
+			auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, host)
+		}
+		notifiers = append(notifiers, smtpNotifier{address: config.SMTPAddress, from: config.SMTPFrom, to: config.SMTPTo, auth: auth})
+	}
+	return notifiers
+}
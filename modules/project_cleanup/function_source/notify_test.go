@@ -0,0 +1,220 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func testNotification() notification {
+	report := newRunReport("run-1", testConfig(), testNow)
+	report.addDeleted(resourceProject, "old-200")
+	report.addScheduled(resourceProject, "old-300", "grace period ends at 2024-01-05T00:00:00Z")
+	report.addBlocked(resourceProject, "locked", "bucket [gs://audit] has a locked retention policy of 30 days")
+	report.addFailed(resourceProject, "broken", errors.New("permission denied"))
+	report.addSkipped(resourceProject, "new-200", "created after the age cutoff")
+	return newNotification(report)
+}
+
+func TestNewNotificationListsProjectEvents(t *testing.T) {
+	n := testNotification()
+	var events []string
+	for _, event := range n.Events {
+		events = append(events, event.Action+" "+event.ProjectId)
+	}
+	if want := []string{"deleted old-200", "scheduled old-300", "blocked locked", "failed broken"}; !sameStrings(events, want) {
+		t.Errorf("got events %v, want %v", events, want)
+	}
+	text := n.text()
+	for _, want := range []string{"run-1", "deleted old-200", "scheduled old-300, grace period ends", "blocked locked, bucket [gs://audit]", "failed broken, error [permission denied]"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text %q does not contain %q", text, want)
+		}
+	}
+	if strings.Contains(text, "new-200") {
+		t.Errorf("skipped projects should not be listed, got %q", text)
+	}
+}
+
+// recordingServer returns an HTTP server recording the JSON body of every request.
+func recordingServer(t *testing.T, status int) (*httptest.Server, *[]map[string]interface{}) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &bodies
+}
+
+func TestWebhookNotifiers(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		notifier func(url string, client *http.Client) notifier
+		field    string
+	}{
+		{"slack", func(url string, client *http.Client) notifier { return slackNotifier{url: url, client: client} }, "text"},
+		{"google chat", func(url string, client *http.Client) notifier { return googleChatNotifier{url: url, client: client} }, "text"},
+		{"webhook", func(url string, client *http.Client) notifier { return webhookNotifier{url: url, client: client} }, "events"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, bodies := recordingServer(t, http.StatusOK)
+			if err := tc.notifier(server.URL, server.Client()).Notify(context.Background(), testNotification()); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if len(*bodies) != 1 || (*bodies)[0][tc.field] == nil {
+				t.Errorf("got request bodies %v, want a %s field", *bodies, tc.field)
+			}
+
+			failing, _ := recordingServer(t, http.StatusForbidden)
+			if err := tc.notifier(failing.URL, failing.Client()).Notify(context.Background(), testNotification()); err == nil {
+				t.Errorf("expected an error for HTTP 403")
+			}
+		})
+	}
+}
+
+// smtpStandIn accepts a single SMTP session on a local port and returns the received message.
+func smtpStandIn(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		write := func(line string) { io.WriteString(conn, line+"\r\n") }
+		write("220 localhost ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					messages <- data.String()
+					write("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				write("250 localhost")
+			case command == "DATA":
+				inData = true
+				write("354 End data with <CR><LF>.<CR><LF>")
+			case command == "QUIT":
+				write("221 Bye")
+				return
+			default:
+				write("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	address, messages := smtpStandIn(t)
+	n := smtpNotifier{address: address, from: "cleaner@example.com", to: []string{"team@example.com"}}
+	if err := n.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	message := <-messages
+	for _, want := range []string{"To: team@example.com", "Subject: Project clean up run run-1 finished", "deleted old-200"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message %q does not contain %q", message, want)
+		}
+	}
+}
+
+func TestSMTPNotifierGivesUpOnUnresponsiveServers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		// Accept the connection but never send the greeting.
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	defer func() {
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		default:
+		}
+	}()
+	n := smtpNotifier{address: listener.Addr().String(), from: "cleaner@example.com", to: []string{"team@example.com"}, timeout: 50 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() { done <- n.Notify(context.Background(), testNotification()) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify did not give up on the unresponsive server")
+	}
+}
+
+func TestRunNotifiesOnlyWhenSomethingHappened(t *testing.T) {
+	recording := &recordingNotifier{}
+	f := newTestHierarchy()
+	f.notifiers = []notifier{recording}
+	newTestCleaner(f, testConfig()).run(context.Background())
+	if len(recording.notifications) != 1 || len(recording.notifications[0].Events) != 2 {
+		t.Fatalf("got notifications %+v, want one with the 2 deleted projects", recording.notifications)
+	}
+
+	empty := newFakeCloud()
+	empty.addFolder(testRootFolderId, "organizations/1", oldTime)
+	empty.notifiers = []notifier{recording}
+	newTestCleaner(empty, testConfig()).run(context.Background())
+	if len(recording.notifications) != 1 {
+		t.Errorf("runs which did nothing should not be notified, got %d notifications", len(recording.notifications))
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// operation is the state of a long-running operation started by a delete call. Clients return
+// a nil operation when the deletion completed synchronously.
+type operation struct {
+	name string
+	done bool
+	// err is the error of an operation which is done and failed.
+	err error
+	// started is when the operation started, zero when unknown.
+	started time.Time
+	// refresh gets the current state of the operation.
+	refresh func(ctx context.Context) (*operation, error)
+}
+
+// Outcomes of a deletion, see completed.
+type deletionOutcome int
+
+const (
+	outcomeDeleted deletionOutcome = iota
+	outcomeFailed
+	outcomeRunning
+)
+
+// waitForOperation polls the operation every OperationPollInterval until it is done, or the
+// timeout has elapsed or the run deadline is reached, and returns its last state and the time
+// spent waiting. The error is the one of the failed operation, or of the last poll.
+func (c *cleaner) waitForOperation(ctx context.Context, op *operation, timeout time.Duration) (*operation, time.Duration, error) {
+	if left, ok := c.remaining(); ok {
+		timeout = min(timeout, left)
+	}
+	waited := time.Duration(0)
+	for ; op != nil && !op.done; waited += c.config.OperationPollInterval {
+		if waited >= timeout || ctx.Err() != nil {
+			return op, waited, nil
+		}
+		c.sleep(c.config.OperationPollInterval)
+		next, err := op.refresh(ctx)
+		if err != nil {
+			return op, waited, fmt.Errorf("failed to get operation [%s], error [%w]", op.name, err)
+		}
+		op = next
+	}
+	if op != nil {
+		return op, waited, op.err
+	}
+	return nil, waited, nil
+}
+
+// completed waits up to OperationTimeout for the operation started by the deletion of the
+// resource and records the outcome: deleted, failed with the error of the call or of the
+// operation, or still running.
+func (c *cleaner) completed(ctx context.Context, resourceType string, name string, op *operation, err error) deletionOutcome {
+	outcome, _ := c.completedWithin(ctx, resourceType, name, op, err, c.config.OperationTimeout)
+	return outcome
+}
+
+// completedWithin is completed with a custom timeout, it also returns the time spent waiting.
+func (c *cleaner) completedWithin(ctx context.Context, resourceType string, name string, op *operation, err error, timeout time.Duration) (deletionOutcome, time.Duration) {
+	var waited time.Duration
+	if err == nil {
+		op, waited, err = c.waitForOperation(ctx, op, timeout)
+	}
+	if err == nil && op != nil && !op.done {
+		c.running(resourceType, name, fmt.Sprintf("operation [%s] still running after %s", op.name, waited))
+		return outcomeRunning, waited
+	}
+	c.deleted(resourceType, name, err)
+	if err != nil {
+		return outcomeFailed, waited
+	}
+	return outcomeDeleted, waited
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/container/apiv1/containerpb"
+	"golang.org/x/net/context"
+)
+
+func operationTestConfig() Config {
+	config := testConfig()
+	config.OperationTimeout = 30 * time.Second
+	config.OperationPollInterval = 10 * time.Second
+	config.ClusterDeletionTimeout = 30 * time.Second
+	config.ClusterStoppingAlert = 24 * time.Hour
+	return config
+}
+
+func TestRunWaitsForOperations(t *testing.T) {
+	f := newTestHierarchy()
+	f.operations["DeleteFolder folders/300"] = 2
+
+	report := newTestCleaner(f, operationTestConfig()).run(context.Background())
+
+	if got := report.resource(resourceFolder).Deleted; !sameStrings(got, []string{"folders/300"}) {
+		t.Errorf("got deleted folders %v, want [folders/300]", got)
+	}
+	polls := 0
+	for _, call := range f.calls {
+		if call == "GetOperation DeleteFolder folders/300" {
+			polls++
+		}
+	}
+	if polls != 2 {
+		t.Errorf("got %d polls of the operation, want 2", polls)
+	}
+}
+
+func TestRunReportsFailedAndRunningOperations(t *testing.T) {
+	f := newTestHierarchy()
+	f.addFolder("400", "folders/200", oldTime)
+	f.operations["DeleteFolder folders/300"] = 1
+	f.operationErrors["DeleteFolder folders/300"] = errors.New("operation failed with code 9, folder is not empty")
+	f.operations["DeleteFolder folders/400"] = -1
+
+	report := newTestCleaner(f, operationTestConfig()).run(context.Background())
+
+	folders := report.resource(resourceFolder)
+	if folders.DeletedCount != 0 || folders.FailedCount != 1 || folders.RunningCount != 1 {
+		t.Fatalf("got %d deleted, %d failed and %d running folders, want 0, 1 and 1", folders.DeletedCount, folders.FailedCount, folders.RunningCount)
+	}
+	if !strings.Contains(folders.Failed[0].Error, "folder is not empty") {
+		t.Errorf("failure does not carry the operation error, got %+v", folders.Failed[0])
+	}
+	if got, want := folders.Running[0].Reason, "operation [operations/DeleteFolder folders/400] still running after 30s"; got != want {
+		t.Errorf("got running reason %q, want %q", got, want)
+	}
+}
+
+func TestRunStopsWaitingForOperationsAtTheRunDeadline(t *testing.T) {
+	f := newTestHierarchy()
+	f.operations["DeleteFolder folders/300"] = -1
+	config := operationTestConfig()
+	config.OperationTimeout = time.Hour
+	config.RunTimeout = 10 * time.Minute
+	config.DeadlineMargin = 9 * time.Minute
+	c := newTestCleaner(f, config)
+	var mu sync.Mutex
+	now := testNow
+	c.clock = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	c.sleep = func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+
+	report := c.run(context.Background())
+
+	folders := report.resource(resourceFolder)
+	if folders.RunningCount != 1 {
+		t.Fatalf("got %d running folders, want 1", folders.RunningCount)
+	}
+	if got, want := folders.Running[0].Reason, "operation [operations/DeleteFolder folders/300] still running after 1m0s"; got != want {
+		t.Errorf("got running reason %q, want %q", got, want)
+	}
+	if !report.Stopped {
+		t.Error("the run should stop once past its deadline")
+	}
+}
+
+func TestRunDeletesProjectsOnceTheirClustersAreGone(t *testing.T) {
+	f := newTestHierarchy()
+	f.clusters["old-200"] = []*containerpb.Cluster{{Name: "gke", Location: "us-central1", Status: containerpb.Cluster_RUNNING}}
+	f.operations["DeleteCluster projects/old-200/locations/us-central1/clusters/gke"] = 1
+
+	report := newTestCleaner(f, operationTestConfig()).run(context.Background())
+
+	if got := report.resource(resourceCluster).DeletedCount; got != 1 {
+		t.Errorf("got %d deleted clusters, want 1", got)
+	}
+	if !f.called("DeleteProject", "old-200") {
+		t.Errorf("project old-200 should be deleted once its cluster deletion is done")
+	}
+}
+
+func TestRunDeletesClustersInError(t *testing.T) {
+	f := newTestHierarchy()
+	f.clusters["old-200"] = []*containerpb.Cluster{{Name: "gke", Location: "us-central1", Status: containerpb.Cluster_ERROR}}
+	f.operations["DeleteCluster projects/old-200/locations/us-central1/clusters/gke"] = 0
+
+	report := newTestCleaner(f, operationTestConfig()).run(context.Background())
+
+	if got := report.resource(resourceCluster).Deleted; !sameStrings(got, []string{"projects/old-200/locations/us-central1/clusters/gke"}) {
+		t.Errorf("got deleted clusters %v, want the cluster in error", got)
+	}
+	if !f.called("DeleteProject", "old-200") {
+		t.Errorf("project old-200 should be deleted once its cluster in error is gone")
+	}
+}
+
+func TestRunSharesTheClusterDeletionTimeout(t *testing.T) {
+	f := newTestHierarchy()
+	f.clusters["old-200"] = []*containerpb.Cluster{
+		{Name: "slow", Location: "us-central1", Status: containerpb.Cluster_RUNNING},
+		{Name: "fast", Location: "us-central1", Status: containerpb.Cluster_RUNNING},
+	}
+	f.operations["DeleteCluster projects/old-200/locations/us-central1/clusters/slow"] = -1
+	f.operations["DeleteCluster projects/old-200/locations/us-central1/clusters/fast"] = 1
+
+	report := newTestCleaner(f, operationTestConfig()).run(context.Background())
+
+	// The slow cluster spends the whole budget, so the fast one is not polled anymore.
+	if got := report.resource(resourceCluster).RunningCount; got != 2 {
+		t.Errorf("got %d running clusters, want 2", got)
+	}
+	if f.called("GetOperation", "DeleteCluster projects/old-200/locations/us-central1/clusters/fast") {
+		t.Errorf("the fast cluster should not be polled once the budget is spent")
+	}
+	if f.called("DeleteProject", "old-200") {
+		t.Errorf("project old-200 should be deferred while a cluster is still being deleted")
+	}
+}
+
+func TestRunWaitsForClustersStoppingSinceAnEarlierRun(t *testing.T) {
+	f := newTestHierarchy()
+	f.clusters["old-200"] = []*containerpb.Cluster{{Name: "gke", Location: "us-central1", Status: containerpb.Cluster_STOPPING}}
+	f.clusters["old-300"] = []*containerpb.Cluster{{Name: "stuck", Location: "us-central1", Status: containerpb.Cluster_STOPPING}}
+	f.operations["DeleteCluster projects/old-200/locations/us-central1/clusters/gke"] = 1
+	f.operationStarts["DeleteCluster projects/old-200/locations/us-central1/clusters/gke"] = testNow.Add(-time.Hour)
+	f.operations["DeleteCluster projects/old-300/locations/us-central1/clusters/stuck"] = -1
+	f.operationStarts["DeleteCluster projects/old-300/locations/us-central1/clusters/stuck"] = testNow.Add(-48 * time.Hour)
+
+	report := newTestCleaner(f, operationTestConfig()).run(context.Background())
+
+	clusters := report.resource(resourceCluster)
+	if !sameStrings(clusters.Deleted, []string{"projects/old-200/locations/us-central1/clusters/gke"}) {
+		t.Errorf("got deleted clusters %v, want the one whose deletion is done", clusters.Deleted)
+	}
+	if f.called("DeleteCluster", "projects/old-200/locations/us-central1/clusters/gke") {
+		t.Errorf("the deletion of a stopping cluster should not be started again")
+	}
+	if !f.called("DeleteProject", "old-200") {
+		t.Errorf("project old-200 should be deleted once its cluster is gone")
+	}
+	if clusters.FailedCount != 1 || !strings.Contains(clusters.Failed[0].Error, "stuck in STOPPING for 48h0m0s") {
+		t.Errorf("the stuck cluster should be reported as failed, got %+v", clusters.Failed)
+	}
+	if f.called("DeleteProject", "old-300") {
+		t.Errorf("project old-300 should be deferred while its cluster is stuck")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// loadProtectedResources builds the resources which are never deleted, whatever the filters
+// and per run overrides: the project hosting the cleaner, its ancestor folders and the
+// resources listed in ProtectedResources. It fails when the host project or one of its ancestors
+// can't be resolved, as the run must not go on without them protected.
+func (c *cleaner) loadProtectedResources(ctx context.Context) error {
+	c.protected = map[string]string{}
+	for _, name := range c.config.ProtectedResources {
+		c.protected[name] = fmt.Sprintf("listed in [%s]", ProtectedResources)
+	}
+	hostProjectId := c.config.HostProjectId
+	if hostProjectId == "" {
+		c.log.Printf("The host project of the cleaner is unknown, only the resources listed in [%s] are protected", ProtectedResources)
+		return nil
+	}
+	c.protected["projects/"+hostProjectId] = "hosts the cleaner"
+	project, err := c.projects.GetProject(ctx, hostProjectId)
+	if err != nil {
+		return fmt.Errorf("failed to get the host project [%s], error [%w]", hostProjectId, err)
+	}
+	if project.ProjectNumber != 0 {
+		c.protected["projects/"+strconv.FormatInt(project.ProjectNumber, 10)] = "hosts the cleaner"
+	}
+	reason := fmt.Sprintf("ancestor of the host project [%s] of the cleaner", hostProjectId)
+	parent := projectParent(project)
+	for strings.HasPrefix(parent, "folders/") {
+		c.protected[parent] = reason
+		folder, err := c.folders.GetFolder(ctx, parent)
+		if err != nil {
+			return fmt.Errorf("failed to get folder [%s], an ancestor of the host project [%s], error [%w]", parent, hostProjectId, err)
+		}
+		parent = folder.Parent
+	}
+	return nil
+}
+
+func projectParent(project *cloudresourcemanager.Project) string {
+	if project.Parent == nil {
+		return ""
+	}
+	return fmt.Sprintf("%ss/%s", project.Parent.Type, project.Parent.Id)
+}
+
+// protectedReason returns why the resource must never be deleted, or an empty string if it
+// isn't protected.
+func (c *cleaner) protectedReason(names ...string) string {
+	for _, name := range names {
+		if reason, ok := c.protected[name]; ok {
+			return fmt.Sprintf("protected, %s", reason)
+		}
+	}
+	return ""
+}
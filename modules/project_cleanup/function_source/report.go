@@ -0,0 +1,320 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/pubsub/v1"
+	"google.golang.org/api/storage/v1"
+)
+
+// maxLoggedErrors caps the errors in the report logged at the end of a run.
+const maxLoggedErrors = 20
+
+// runReport summarizes what a single invocation did, per resource type.
+type runReport struct {
+	RunID                string                     `json:"run_id"`
+	StartTime            time.Time                  `json:"start_time"`
+	EndTime              time.Time                  `json:"end_time"`
+	DryRun               bool                       `json:"dry_run"`
+	TargetFolderIds      []string                   `json:"target_folder_ids,omitempty"`
+	OrganizationProjects bool                       `json:"organization_projects,omitempty"`
+	Resources            map[string]*resourceReport `json:"resources"`
+	Errors               []string                   `json:"errors,omitempty"`
+	// ResumedRunID is the run whose checkpoint this run resumed from.
+	ResumedRunID string `json:"resumed_run_id,omitempty"`
+	// Stopped is set when the run stopped before its deadline and saved a checkpoint.
+	Stopped bool `json:"stopped,omitempty"`
+	// Retries counts the retried calls per API.
+	Retries map[string]int `json:"retries,omitempty"`
+	errs    []error
+	// mu guards the report while projects are cleaned up concurrently.
+	mu *sync.Mutex
+}
+
+// resourceReport holds the outcome for every resource of a single type.
+type resourceReport struct {
+	DeletedCount   int          `json:"deleted_count"`
+	PlannedCount   int          `json:"planned_count"`
+	DeferredCount  int          `json:"deferred_count"`
+	ScheduledCount int          `json:"scheduled_count"`
+	RunningCount   int          `json:"running_count"`
+	SkippedCount   int          `json:"skipped_count"`
+	BlockedCount   int          `json:"blocked_count"`
+	FailedCount    int          `json:"failed_count"`
+	Deleted        []string     `json:"deleted,omitempty"`
+	Planned        []string     `json:"planned,omitempty"`
+	Deferred       []reportItem `json:"deferred,omitempty"`
+	Scheduled      []reportItem `json:"scheduled,omitempty"`
+	Running        []reportItem `json:"running,omitempty"`
+	Skipped        []reportItem `json:"skipped,omitempty"`
+	Blocked        []reportItem `json:"blocked,omitempty"`
+	Failed         []reportItem `json:"failed,omitempty"`
+}
+
+type reportItem struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newRunReport(runID string, config Config, startTime time.Time) *runReport {
+	var targetFolderIds []string
+	for _, target := range config.targets() {
+		targetFolderIds = append(targetFolderIds, target.FolderId)
+	}
+	return &runReport{
+		TargetFolderIds:      targetFolderIds,
+		RunID:                runID,
+		StartTime:            startTime,
+		DryRun:               config.DryRun,
+		OrganizationProjects: config.CleanUpOrganizationProjects,
+		Resources:            map[string]*resourceReport{},
+		mu:                   &sync.Mutex{},
+	}
+}
+
+func (r *runReport) resource(resourceType string) *resourceReport {
+	report, ok := r.Resources[resourceType]
+	if !ok {
+		report = &resourceReport{}
+		r.Resources[resourceType] = report
+	}
+	return report
+}
+
+func (r *runReport) addDeleted(resourceType string, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.resource(resourceType)
+	report.DeletedCount++
+	report.Deleted = append(report.Deleted, name)
+}
+
+func (r *runReport) addPlanned(resourceType string, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.resource(resourceType)
+	report.PlannedCount++
+	report.Planned = append(report.Planned, name)
+}
+
+// addBlocked records a resource which can't be deleted, it is not an error of the run.
+func (r *runReport) addBlocked(resourceType string, name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.resource(resourceType)
+	report.BlockedCount++
+	report.Blocked = append(report.Blocked, reportItem{Name: name, Reason: reason})
+}
+
+func (r *runReport) addRunning(resourceType string, name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.resource(resourceType)
+	report.RunningCount++
+	report.Running = append(report.Running, reportItem{Name: name, Reason: reason})
+}
+
+func (r *runReport) addDeferred(resourceType string, name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.resource(resourceType)
+	report.DeferredCount++
+	report.Deferred = append(report.Deferred, reportItem{Name: name, Reason: reason})
+}
+
+func (r *runReport) addScheduled(resourceType string, name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.resource(resourceType)
+	report.ScheduledCount++
+	report.Scheduled = append(report.Scheduled, reportItem{Name: name, Reason: reason})
+}
+
+func (r *runReport) addSkipped(resourceType string, name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.resource(resourceType)
+	report.SkippedCount++
+	report.Skipped = append(report.Skipped, reportItem{Name: name, Reason: reason})
+}
+
+func (r *runReport) addFailed(resourceType string, name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.resource(resourceType)
+	report.FailedCount++
+	report.Failed = append(report.Failed, reportItem{Name: name, Error: err.Error()})
+	r.appendError(fmt.Errorf("delete %s [%s]: %w", resourceTypeText(resourceType), name, err))
+}
+
+func (r *runReport) addRetry(api string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Retries == nil {
+		r.Retries = map[string]int{}
+	}
+	r.Retries[api]++
+}
+
+func (r *runReport) addError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appendError(err)
+}
+
+func (r *runReport) appendError(err error) {
+	r.errs = append(r.errs, err)
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// counts returns a copy of the report without the resource names and with at most
+// maxLoggedErrors errors, which keeps its log entry below the Cloud Logging entry size limit
+// whatever the number of resources. Every resource has its own log entry already, and the
+// report sinks get the full report.
+func (r *runReport) counts() *runReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := *r
+	counts.Resources = map[string]*resourceReport{}
+	for resourceType, report := range r.Resources {
+		counts.Resources[resourceType] = &resourceReport{
+			DeletedCount:   report.DeletedCount,
+			PlannedCount:   report.PlannedCount,
+			DeferredCount:  report.DeferredCount,
+			ScheduledCount: report.ScheduledCount,
+			RunningCount:   report.RunningCount,
+			SkippedCount:   report.SkippedCount,
+			BlockedCount:   report.BlockedCount,
+			FailedCount:    report.FailedCount,
+		}
+	}
+	if len(r.Errors) > maxLoggedErrors {
+		counts.Errors = append(r.Errors[:maxLoggedErrors:maxLoggedErrors], fmt.Sprintf("and %d more errors, see the full report", len(r.Errors)-maxLoggedErrors))
+	}
+	return &counts
+}
+
+// err aggregates every error of the run, it returns nil if the run had no errors.
+func (r *runReport) err() error {
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("clean up run [%s] finished with [%d] errors: %w", r.RunID, len(r.errs), errors.Join(r.errs...))
+}
+
+// summary returns a one line overview of the report, e.g. "project: 2 deleted, 1 skipped".
+func (r *runReport) summary() string {
+	var parts []string
+	for _, resourceType := range sortedKeys(r.Resources) {
+		report := r.Resources[resourceType]
+		var counts []string
+		for _, count := range []struct {
+			name  string
+			value int
+		}{
+			{"deleted", report.DeletedCount},
+			{"planned", report.PlannedCount},
+			{"deferred", report.DeferredCount},
+			{"scheduled", report.ScheduledCount},
+			{"still running", report.RunningCount},
+			{"skipped", report.SkippedCount},
+			{"blocked", report.BlockedCount},
+			{"failed", report.FailedCount},
+		} {
+			if count.value > 0 {
+				counts = append(counts, fmt.Sprintf("%d %s", count.value, count.name))
+			}
+		}
+		if len(counts) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", resourceTypeText(resourceType), strings.Join(counts, ", ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "nothing to clean up"
+	}
+	retries := 0
+	for _, count := range r.Retries {
+		retries += count
+	}
+	if retries > 0 {
+		parts = append(parts, fmt.Sprintf("%d retried calls", retries))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func sortedKeys(m map[string]*resourceReport) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// reportSink receives the JSON encoded report at the end of every run.
+type reportSink interface {
+	WriteReport(ctx context.Context, report *runReport, data []byte) error
+}
+
+// gcsReportSink writes every report to its own object in a Cloud Storage bucket.
+type gcsReportSink struct {
+	service *storage.Service
+	bucket  string
+	prefix  string
+}
+
+func (s gcsReportSink) objectName(report *runReport) string {
+	return fmt.Sprintf("%s%s-%s.json", s.prefix, report.StartTime.UTC().Format("20060102T150405Z"), report.RunID)
+}
+
+func (s gcsReportSink) WriteReport(ctx context.Context, report *runReport, data []byte) error {
+	object := &storage.Object{Name: s.objectName(report), ContentType: "application/json"}
+	_, err := s.service.Objects.Insert(s.bucket, object).Media(bytes.NewReader(data)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to write report to [gs://%s/%s], error [%s]", s.bucket, object.Name, err.Error())
+	}
+	return nil
+}
+
+// pubSubReportSink publishes every report as a single message to a Pub/Sub topic.
+type pubSubReportSink struct {
+	service *pubsub.Service
+	topic   string
+}
+
+func (s pubSubReportSink) WriteReport(ctx context.Context, report *runReport, data []byte) error {
+	req := &pubsub.PublishRequest{Messages: []*pubsub.PubsubMessage{{
+		Data:       base64.StdEncoding.EncodeToString(data),
+		Attributes: map[string]string{"run_id": report.RunID},
+	}}}
+	_, err := s.service.Projects.Topics.Publish(s.topic, req).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to publish report to [%s], error [%s]", s.topic, err.Error())
+	}
+	return nil
+}
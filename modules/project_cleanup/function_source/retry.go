@@ -0,0 +1,120 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxRetryDelay caps the exponential backoff between two attempts.
+const maxRetryDelay = time.Minute
+
+// retryPolicy retries calls failing with transient errors with an exponential backoff.
+type retryPolicy struct {
+	// maxAttempts is the total number of attempts, including the first one.
+	maxAttempts  int
+	initialDelay time.Duration
+	// maxElapsed stops retrying once waiting for the next attempt would exceed it.
+	maxElapsed time.Duration
+	// random returns a number in [0, 1) used to jitter the delays, replaced in tests.
+	random func() float64
+	// sleep waits for the delay unless ctx is done first, replaced in tests.
+	sleep func(ctx context.Context, delay time.Duration) error
+	// remaining returns the time left before the run deadline, false if there is none. Retrying
+	// stops once waiting for the next attempt would pass the deadline.
+	remaining func() (time.Duration, bool)
+	// onRetry is called before waiting to retry a failed call to api.
+	onRetry func(api string, err error, delay time.Duration)
+}
+
+func newRetryPolicy(config Config) retryPolicy {
+	return retryPolicy{
+		maxAttempts:  config.RetryMaxAttempts,
+		initialDelay: config.RetryInitialDelay,
+		maxElapsed:   config.RetryMaxElapsed,
+		random:       rand.Float64,
+		sleep:        sleepContext,
+	}
+}
+
+// do calls call until it succeeds, fails with an error which is not retryable, or the
+// policy is exhausted, and returns the last error.
+func (p retryPolicy) do(ctx context.Context, api string, call func() error) error {
+	start := time.Now()
+	delay := p.initialDelay
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= p.maxAttempts || !isRetryableError(err) {
+			return err
+		}
+		// Jitter keeps the concurrent project workers from retrying in lockstep.
+		wait := time.Duration(float64(delay) * (0.5 + p.random()/2))
+		if p.maxElapsed > 0 && time.Since(start)+wait > p.maxElapsed {
+			return err
+		}
+		if p.remaining != nil {
+			if left, ok := p.remaining(); ok && wait > left {
+				return err
+			}
+		}
+		if p.onRetry != nil {
+			p.onRetry(api, err, wait)
+		}
+		if p.sleep(ctx, wait) != nil {
+			return err
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isRetryableError reports whether err is a transient error of a REST or gRPC API.
+func isRetryableError(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch gerr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+			return true
+		}
+	}
+	return false
+}
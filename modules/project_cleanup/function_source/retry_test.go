@@ -0,0 +1,151 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&googleapi.Error{Code: 429}, true},
+		{&googleapi.Error{Code: 503}, true},
+		{fmt.Errorf("wrapped: %w", &googleapi.Error{Code: 502}), true},
+		{&googleapi.Error{Code: 404}, false},
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{status.Error(codes.ResourceExhausted, "quota"), true},
+		{status.Error(codes.Aborted, "conflict"), true},
+		{status.Error(codes.NotFound, "not found"), false},
+		{errors.New("plain"), false},
+	} {
+		if got := isRetryableError(tc.err); got != tc.want {
+			t.Errorf("isRetryableError(%v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+func testRetryPolicy(delays *[]time.Duration) retryPolicy {
+	return retryPolicy{
+		maxAttempts:  4,
+		initialDelay: time.Second,
+		maxElapsed:   time.Hour,
+		random:       func() float64 { return 0 },
+		sleep: func(ctx context.Context, delay time.Duration) error {
+			*delays = append(*delays, delay)
+			return ctx.Err()
+		},
+	}
+}
+
+func TestRetryPolicyBacksOffUntilSuccess(t *testing.T) {
+	var delays []time.Duration
+	var retried []string
+	policy := testRetryPolicy(&delays)
+	policy.onRetry = func(api string, err error, delay time.Duration) { retried = append(retried, api) }
+	attempts := 0
+	err := policy.do(context.Background(), apiContainer, func() error {
+		attempts++
+		if attempts < 3 {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("got error %v after %d attempts, want success after 3", err, attempts)
+	}
+	if want := []time.Duration{500 * time.Millisecond, time.Second}; fmt.Sprint(delays) != fmt.Sprint(want) {
+		t.Errorf("got delays %v, want %v", delays, want)
+	}
+	if len(retried) != 2 || retried[0] != apiContainer {
+		t.Errorf("got retries %v, want two for %s", retried, apiContainer)
+	}
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	unavailable := &googleapi.Error{Code: 503}
+	for _, tc := range []struct {
+		name         string
+		err          error
+		modify       func(*retryPolicy)
+		ctx          func() context.Context
+		wantAttempts int
+	}{
+		{name: "non retryable", err: &googleapi.Error{Code: 403}, wantAttempts: 1},
+		{name: "max attempts", err: unavailable, wantAttempts: 4},
+		{name: "max elapsed", err: unavailable, modify: func(p *retryPolicy) { p.maxElapsed = 900 * time.Millisecond }, wantAttempts: 1},
+		{name: "run deadline", err: unavailable, modify: func(p *retryPolicy) {
+			p.remaining = func() (time.Duration, bool) { return 900 * time.Millisecond, true }
+		}, wantAttempts: 1},
+		{name: "cancelled", err: unavailable, ctx: func() context.Context {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx
+		}, wantAttempts: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var delays []time.Duration
+			policy := testRetryPolicy(&delays)
+			policy.random = func() float64 { return 0.99 }
+			if tc.modify != nil {
+				tc.modify(&policy)
+			}
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx()
+			}
+			attempts := 0
+			err := policy.do(ctx, apiResourceManager, func() error {
+				attempts++
+				return tc.err
+			})
+			if !errors.Is(err, tc.err) || attempts != tc.wantAttempts {
+				t.Errorf("got error %v after %d attempts, want %v after %d", err, attempts, tc.err, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRunRetriesTransientErrors(t *testing.T) {
+	f := newTestHierarchy()
+	f.unavailable["DeleteProject old-300"] = 2
+	f.unavailable["DeleteFolder folders/300"] = 5
+	config := testConfig()
+	config.RetryMaxAttempts = 3
+
+	report := newTestCleaner(f, config).run(context.Background())
+
+	if got, want := report.resource(resourceProject).Deleted, []string{"old-200", "old-300"}; !sameStrings(got, want) {
+		t.Errorf("got deleted projects %v, want %v", got, want)
+	}
+	if got := report.resource(resourceFolder).FailedCount; got != 1 {
+		t.Errorf("got %d failed folders, want 1 after exhausting the attempts", got)
+	}
+	if got := report.Retries[apiResourceManager]; got != 4 {
+		t.Errorf("got %d retries, want 4", got)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/compute/v1"
+)
+
+// sharedVPCHost is a Shared VPC host project about to be deleted.
+type sharedVPCHost struct {
+	projectId string
+	// serviceProjects are the attached service projects which are cleaned up by the run too,
+	// they are detached before the host project is disabled.
+	serviceProjects []string
+	// kept describes the attached service projects which are not cleaned up, e.g. outside of
+	// the targets, which block the deletion of the host project.
+	kept []string
+}
+
+// findSharedVPCHost returns the Shared VPC host project with its service projects, nil if the
+// project isn't a host project or its Shared VPC status couldn't be read.
+func (c *cleaner) findSharedVPCHost(ctx context.Context, projectId string) *sharedVPCHost {
+	isHost, err := c.sharedVPC.IsXpnHost(ctx, projectId)
+	if err != nil && !isServiceDisabled(err) {
+		c.errorf("failed to get the Shared VPC status of project [%s], error [%w]", projectId, err)
+	}
+	if !isHost {
+		return nil
+	}
+	var serviceProjects []string
+	err = c.sharedVPC.ListXpnResources(ctx, projectId, func(page *compute.ProjectsGetXpnResources) error {
+		for _, resource := range page.Resources {
+			if resource.Type == "PROJECT" {
+				serviceProjects = append(serviceProjects, resource.Id)
+			}
+		}
+		return nil
+	})
+	c.listed(resourceSharedVPCServiceProject, projectId, len(serviceProjects), err)
+	host := &sharedVPCHost{projectId: projectId}
+	if err != nil {
+		host.kept = append(host.kept, "failed to list them")
+	}
+	for _, serviceProjectId := range serviceProjects {
+		if reason := c.serviceProjectKeepReason(ctx, serviceProjectId); reason != "" {
+			host.kept = append(host.kept, fmt.Sprintf("%s: %s", serviceProjectId, reason))
+			continue
+		}
+		host.serviceProjects = append(host.serviceProjects, serviceProjectId)
+	}
+	return host
+}
+
+// serviceProjectKeepReason returns why the service project must stay attached to its host
+// project, or an empty string if it can be detached. Only the service projects which are
+// deleted already or are deleted by the run can be detached: the ones kept by a filter, a tag,
+// a preserved lien, their grace period or a blocker stay attached.
+func (c *cleaner) serviceProjectKeepReason(ctx context.Context, projectId string) string {
+	project, err := c.projects.GetProject(ctx, projectId)
+	if err != nil {
+		return fmt.Sprintf("failed to get it, error [%s]", err.Error())
+	}
+	if !activeProjectFilter(project) {
+		return ""
+	}
+	inTargets, err := c.inTargets(ctx, project)
+	if err != nil {
+		return fmt.Sprintf("failed to get its ancestors, error [%s]", err.Error())
+	}
+	if !inTargets {
+		return "outside of the target folders"
+	}
+	if reason := c.projectSkipReason(project); reason != "" {
+		return reason
+	}
+	if reason, _ := c.projectTagsSkipReason(ctx, project); reason != "" {
+		return reason
+	}
+	if reason := c.serviceProjectLiensReason(ctx, projectId); reason != "" {
+		return reason
+	}
+	if c.config.DeletionGracePeriodHours > 0 {
+		deleteAfter, scheduled := c.gracePeriodEnd(project)
+		if !scheduled {
+			return "deletion not scheduled yet"
+		}
+		if c.now.Before(deleteAfter) {
+			return fmt.Sprintf("deletion scheduled, grace period ends at %s", deleteAfter.Format(time.RFC3339))
+		}
+	}
+	if blockers := c.bucketBlockers(ctx, projectId); len(blockers) > 0 {
+		return strings.Join(blockers, "; ")
+	}
+	return ""
+}
+
+// serviceProjectLiensReason returns why the liens of the service project keep it, or an empty
+// string if the run can remove all of them.
+func (c *cleaner) serviceProjectLiensReason(ctx context.Context, projectId string) string {
+	parent := fmt.Sprintf("projects/%s", projectId)
+	var held []string
+	err := c.liens.ListLiens(ctx, parent, func(page *cloudresourcemanager.ListLiensResponse) error {
+		for _, lien := range page.Liens {
+			if c.lienSkipReason(lien) != "" {
+				held = append(held, fmt.Sprintf("%s/%s", parent, lien.Name))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Sprintf("failed to list its liens, error [%s]", err.Error())
+	}
+	if len(held) > 0 {
+		return fmt.Sprintf("held by liens [%s]", strings.Join(held, ", "))
+	}
+	return ""
+}
+
+// inTargets reports whether the project is below one of the target folders, or directly in
+// the organization when its projects are cleaned up.
+func (c *cleaner) inTargets(ctx context.Context, project *cloudresourcemanager.Project) (bool, error) {
+	if project.Parent == nil {
+		return false, nil
+	}
+	if project.Parent.Type == "organization" {
+		return c.config.CleanUpOrganizationProjects && project.Parent.Id == c.config.OrganizationId, nil
+	}
+	parent := projectParent(project)
+	for strings.HasPrefix(parent, "folders/") {
+		if c.targetFolders[parent] {
+			return true, nil
+		}
+		folder, err := c.folders.GetFolder(ctx, parent)
+		if err != nil {
+			return false, err
+		}
+		parent = folder.Parent
+	}
+	return false, nil
+}
+
+// disableSharedVPCHost detaches the service projects from the Shared VPC host project and then
+// disables it, it reports whether the host project can be deleted.
+func (c *cleaner) disableSharedVPCHost(ctx context.Context, host *sharedVPCHost) bool {
+	detached := true
+	for _, serviceProjectId := range host.serviceProjects {
+		name := fmt.Sprintf("%s/%s", host.projectId, serviceProjectId)
+		if c.skipInDryRun(resourceSharedVPCServiceProject, name) {
+			continue
+		}
+		op, err := c.sharedVPC.DisableXpnResource(ctx, host.projectId, serviceProjectId)
+		if c.completed(ctx, resourceSharedVPCServiceProject, name, op, err) != outcomeDeleted {
+			detached = false
+		}
+	}
+	if !detached {
+		return false
+	}
+	if c.skipInDryRun(resourceSharedVPCHost, host.projectId) {
+		return true
+	}
+	op, err := c.sharedVPC.DisableXpnHost(ctx, host.projectId)
+	return c.completed(ctx, resourceSharedVPCHost, host.projectId, op, err) == outcomeDeleted
+}
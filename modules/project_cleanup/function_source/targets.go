@@ -0,0 +1,163 @@
+/*
+Copyright 2024 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package project_cleanup
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// projectFilters overrides the project filters of a Config, fields which are not set keep
+// the configured value. A label map or tag list which is set replaces the configured one,
+// {} or [] clears it.
+type projectFilters struct {
+	MaxProjectAgeHours    *int64            `json:"max_project_age_hours"`
+	IncludedLabels        map[string]string `json:"target_included_labels"`
+	ExcludedLabels        map[string]string `json:"target_excluded_labels"`
+	IncludedLabelSelector *string           `json:"target_included_label_selector"`
+	ExcludedLabelSelector *string           `json:"target_excluded_label_selector"`
+	IncludedTags          []string          `json:"target_included_tags"`
+	ExcludedTags          []string          `json:"target_excluded_tags"`
+	IncludedProjects      []string          `json:"target_included_projects"`
+	ExcludedProjects      []string          `json:"target_excluded_projects"`
+}
+
+// TargetFolder is a root folder to clean up with its own optional project filters, e.g.
+// {"folder_id": "123", "max_project_age_hours": 168, "target_included_labels": {"env": "sandbox"}}.
+type TargetFolder struct {
+	projectFilters
+	FolderId string `json:"folder_id"`
+}
+
+func (f projectFilters) apply(config Config) (Config, []error) {
+	var errs []error
+	if f.MaxProjectAgeHours != nil {
+		if *f.MaxProjectAgeHours < 0 {
+			errs = append(errs, fmt.Errorf("[max_project_age_hours] must be at least 0, got %d", *f.MaxProjectAgeHours))
+		}
+		config.MaxProjectAgeHours = *f.MaxProjectAgeHours
+	}
+	if f.IncludedLabels != nil {
+		config.IncludedLabels = f.IncludedLabels
+	}
+	if f.ExcludedLabels != nil {
+		config.ExcludedLabels = f.ExcludedLabels
+	}
+	for _, selector := range []struct {
+		name   string
+		value  *string
+		target *LabelSelector
+	}{
+		{"target_included_label_selector", f.IncludedLabelSelector, &config.IncludedLabelSelector},
+		{"target_excluded_label_selector", f.ExcludedLabelSelector, &config.ExcludedLabelSelector},
+	} {
+		if selector.value == nil {
+			continue
+		}
+		parsed, err := ParseLabelSelector(*selector.value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for [%s], %s", selector.name, err.Error()))
+		}
+		*selector.target = parsed
+	}
+	for _, tags := range []struct {
+		name   string
+		value  []string
+		target *[]string
+	}{
+		{"target_included_tags", f.IncludedTags, &config.IncludedTags},
+		{"target_excluded_tags", f.ExcludedTags, &config.ExcludedTags},
+	} {
+		if tags.value == nil {
+			continue
+		}
+		for _, tag := range tags.value {
+			if !regexp.MustCompile(namespacedTagRegexp).MatchString(tag) {
+				errs = append(errs, fmt.Errorf("invalid tag [%s] in [%s], it must match [%s]", tag, tags.name, namespacedTagRegexp))
+			}
+		}
+		*tags.target = tags.value
+	}
+	for _, patterns := range []struct {
+		name   string
+		value  []string
+		target *[]*regexp.Regexp
+	}{
+		{"target_included_projects", f.IncludedProjects, &config.IncludedProjects},
+		{"target_excluded_projects", f.ExcludedProjects, &config.ExcludedProjects},
+	} {
+		if patterns.value == nil {
+			continue
+		}
+		compiled := []*regexp.Regexp{}
+		for _, pattern := range patterns.value {
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid regular expression [%s] in [%s], error [%s]", pattern, patterns.name, err.Error()))
+				continue
+			}
+			compiled = append(compiled, regex)
+		}
+		*patterns.target = compiled
+	}
+	return config, errs
+}
+
+// targets returns every root folder to clean up, RootFolderId first.
+func (config Config) targets() []TargetFolder {
+	var targets []TargetFolder
+	if config.RootFolderId != "" {
+		targets = append(targets, TargetFolder{FolderId: config.RootFolderId})
+	}
+	return append(targets, config.TargetFolders...)
+}
+
+// forTarget returns the configuration used to clean up the target folder.
+func (config Config) forTarget(target TargetFolder) Config {
+	config, _ = target.apply(config)
+	config.RootFolderId = target.FolderId
+	config.TargetFolders = nil
+	return config
+}
+
+// validateTargets checks there is something to clean up and that every target folder is
+// valid, the names of the settings the targets were read from are used in the errors.
+func (config Config) validateTargets(folderIdName string, foldersName string, organizationProjectsName string) []error {
+	var errs []error
+	if config.RootFolderId == "" && len(config.TargetFolders) == 0 && !config.CleanUpOrganizationProjects {
+		errs = append(errs, fmt.Errorf("one of [%s], [%s] or [%s] must be set", folderIdName, foldersName, organizationProjectsName))
+	}
+	folderIdRegexp := regexp.MustCompile(targetFolderRegexp)
+	if config.RootFolderId != "" && !folderIdRegexp.MatchString(config.RootFolderId) {
+		errs = append(errs, fmt.Errorf("invalid value [%s] for [%s], it must match [%s]", config.RootFolderId, folderIdName, targetFolderRegexp))
+	}
+	for i, target := range config.TargetFolders {
+		if !folderIdRegexp.MatchString(target.FolderId) {
+			errs = append(errs, fmt.Errorf("invalid folder_id [%s] for target folder %d in [%s], it must match [%s]", target.FolderId, i, foldersName, targetFolderRegexp))
+		}
+		for _, err := range target.errors() {
+			errs = append(errs, fmt.Errorf("invalid target folder [%s] in [%s]: %w", target.FolderId, foldersName, err))
+		}
+	}
+	return errs
+}
+
+// errors returns the problems with the project filters of the target folder.
+func (target TargetFolder) errors() []error {
+	_, errs := target.apply(Config{})
+	return errs
+}
@@ -32,6 +32,7 @@ resource "google_organization_iam_member" "main" {
     "roles/serviceusage.serviceUsageAdmin",
     "roles/compute.orgSecurityResourceAdmin",
     "roles/compute.orgSecurityPolicyAdmin",
+    "roles/compute.xpnAdmin",
     "roles/resourcemanager.tagAdmin",
     "roles/viewer",
     "roles/cloudasset.owner",
@@ -49,6 +50,7 @@ module "scheduled_project_cleaner" {
   project_id                     = var.project_id
   job_name                       = "project-cleaner"
   job_schedule                   = var.job_schedule
+  message_data                   = length(var.job_message_overrides) > 0 ? base64encode(jsonencode(var.job_message_overrides)) : "dGVzdA=="
   function_entry_point           = "CleanUpProjects"
   function_source_directory      = "${path.module}/function_source"
   function_name                  = "old-project-cleaner"
@@ -61,12 +63,32 @@ module "scheduled_project_cleaner" {
   function_timeout_s             = var.function_timeout_s
   function_docker_registry       = var.function_docker_registry
 
+  function_event_trigger_failure_policy_retry = var.function_event_trigger_failure_policy_retry
+
   function_environment_variables = {
     TARGET_ORGANIZATION_ID            = var.organization_id
     TARGET_FOLDER_ID                  = var.target_folder_id
+    TARGET_FOLDERS                    = jsonencode(var.target_folders)
+    CLEAN_UP_ORGANIZATION_PROJECTS    = var.clean_up_organization_projects
+    TARGET_INCLUDED_FOLDERS           = jsonencode(var.target_included_folders)
+    TARGET_EXCLUDED_FOLDERS           = jsonencode(var.target_excluded_folders)
+    TARGET_EXCLUDED_FOLDER_TAGS       = jsonencode(var.target_excluded_folder_tags)
+    FOLDER_DELETION_MODE              = var.folder_deletion_mode
+    PROTECTED_FOLDER_DEPTH            = var.protected_folder_depth
+    PROTECTED_RESOURCES               = jsonencode(var.protected_resources)
+    HOST_PROJECT_ID                   = var.project_id
     TARGET_EXCLUDED_LABELS            = jsonencode(var.target_excluded_labels)
     TARGET_INCLUDED_LABELS            = jsonencode(local.target_included_labels)
+    TARGET_EXCLUDED_LABEL_SELECTOR    = var.target_excluded_label_selector
+    TARGET_INCLUDED_LABEL_SELECTOR    = var.target_included_label_selector
+    TARGET_EXCLUDED_PROJECTS          = jsonencode(var.target_excluded_projects)
+    TARGET_INCLUDED_PROJECTS          = jsonencode(var.target_included_projects)
+    TARGET_EXCLUDED_TAGS              = jsonencode(var.target_excluded_tags)
+    TARGET_INCLUDED_TAGS              = jsonencode(var.target_included_tags)
     MAX_PROJECT_AGE_HOURS             = var.max_project_age_in_hours
+    DELETION_GRACE_PERIOD_HOURS       = var.deletion_grace_period_hours
+    PRESERVED_LIENS                   = jsonencode(var.preserved_liens)
+    REMOVABLE_LIEN_ORIGINS            = jsonencode(var.removable_lien_origins)
     CLEAN_UP_TAG_KEYS                 = var.clean_up_org_level_tag_keys
     TARGET_EXCLUDED_TAGKEYS           = jsonencode(var.target_excluded_tagkeys)
     CLEAN_UP_SCC_NOTIFICATIONS        = var.clean_up_org_level_scc_notifications
@@ -78,5 +100,35 @@ module "scheduled_project_cleaner" {
     CLEAN_UP_BILLING_SINKS            = var.clean_up_billing_sinks
     TARGET_BILLING_SINKS              = jsonencode(var.target_billing_sinks)
     BILLING_SINKS_PAGE_SIZE           = var.list_billing_sinks_page_size
+    ENDPOINTS_SERVICES_PAGE_SIZE      = var.list_endpoints_services_page_size
+    FIREWALL_POLICIES_PAGE_SIZE       = var.list_firewall_policies_page_size
+    TAG_KEYS_PAGE_SIZE                = var.list_tag_keys_page_size
+    TAG_VALUES_PAGE_SIZE              = var.list_tag_values_page_size
+    DRY_RUN                           = var.dry_run
+    REPORT_GCS_BUCKET                 = var.report_gcs_bucket
+    REPORT_GCS_PREFIX                 = var.report_gcs_prefix
+    REPORT_PUBSUB_TOPIC               = var.report_pubsub_topic
+    RETURN_ERROR_ON_FAILURE           = var.return_error_on_failure
+    PROJECT_PARALLELISM               = var.project_parallelism
+    MAX_CONCURRENT_API_CALLS          = var.max_concurrent_api_calls
+    RETRY_MAX_ATTEMPTS                = var.retry_max_attempts
+    OPERATION_TIMEOUT_SECONDS         = var.operation_timeout_seconds
+    OPERATION_POLL_INTERVAL_SECONDS   = var.operation_poll_interval_seconds
+    CLUSTER_DELETION_TIMEOUT_SECONDS  = var.cluster_deletion_timeout_seconds
+    CLUSTER_STOPPING_ALERT_HOURS      = var.cluster_stopping_alert_hours
+    FUNCTION_TIMEOUT_SECONDS          = var.function_timeout_s
+    DEADLINE_MARGIN_SECONDS           = var.deadline_margin_seconds
+    CHECKPOINT_GCS_BUCKET             = var.checkpoint_gcs_bucket
+    CHECKPOINT_GCS_OBJECT             = var.checkpoint_gcs_object
+    RETRY_INITIAL_DELAY_MS            = var.retry_initial_delay_ms
+    RETRY_MAX_ELAPSED_SECONDS         = var.retry_max_elapsed_seconds
+    NOTIFY_SLACK_WEBHOOK_URL          = var.notify_slack_webhook_url
+    NOTIFY_GOOGLE_CHAT_WEBHOOK_URL    = var.notify_google_chat_webhook_url
+    NOTIFY_WEBHOOK_URL                = var.notify_webhook_url
+    NOTIFY_SMTP_ADDRESS               = var.notify_smtp_address
+    NOTIFY_SMTP_FROM                  = var.notify_smtp_from
+    NOTIFY_SMTP_TO                    = jsonencode(var.notify_smtp_to)
+    NOTIFY_SMTP_USERNAME              = var.notify_smtp_username
+    NOTIFY_SMTP_PASSWORD              = var.notify_smtp_password
   }
 }
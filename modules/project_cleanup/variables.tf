@@ -53,6 +53,12 @@ variable "job_schedule" {
   default     = "*/5 * * * *"
 }
 
+variable "job_message_overrides" {
+  type        = any
+  description = "Overrides sent as the JSON Pub/Sub message payload of the scheduled job, for example `{target_folder_id = \"123\", max_project_age_hours = 168}`. See the function README for the supported keys."
+  default     = {}
+}
+
 variable "topic_name" {
   type        = string
   description = "Name of pubsub topic connecting the scheduled projects cleanup function"
@@ -71,6 +77,48 @@ variable "target_tag_value" {
   default     = ""
 }
 
+variable "target_included_projects" {
+  type        = list(string)
+  description = "List of regular expressions. Only projects whose ID, display name or number matches at least one of them will be deleted, e.g. `^ci-[a-z0-9]{6}$`."
+  default     = []
+}
+
+variable "target_excluded_projects" {
+  type        = list(string)
+  description = "List of regular expressions. Projects whose ID, display name or number matches one of them won't be deleted, regardless of their labels."
+  default     = []
+}
+
+variable "target_included_tags" {
+  type        = list(string)
+  description = "List of namespaced tag keys or values, e.g. `123456789/env` or `123456789/env/ci`. Only projects with at least one of them among their effective tags, including tags inherited from folders, will be deleted."
+  default     = []
+}
+
+variable "target_excluded_tags" {
+  type        = list(string)
+  description = "List of namespaced tag keys or values, e.g. `123456789/protected`. Projects with one of them among their effective tags, including tags inherited from folders, won't be deleted."
+  default     = []
+}
+
+variable "preserved_liens" {
+  type        = list(string)
+  description = "List of regular expressions matched against the reason and origin of project liens. Projects holding a matching lien won't be deleted and keep all their liens."
+  default     = []
+}
+
+variable "removable_lien_origins" {
+  type        = list(string)
+  description = "List of regular expressions matched against the origin of project liens. If set, only matching liens are removed and projects holding any other lien won't be deleted. All liens are removed if empty."
+  default     = []
+}
+
+variable "deletion_grace_period_hours" {
+  type        = number
+  description = "If greater than 0, matching projects are first labeled with `cleanup-scheduled-at` and only deleted by a run at least this many hours later. The function service account then needs the `resourcemanager.projects.update` permission."
+  default     = 0
+}
+
 variable "max_project_age_in_hours" {
   type        = number
   description = "The maximum number of hours that a GCP project, selected by `target_tag_name` and `target_tag_value`, can exist"
@@ -89,6 +137,18 @@ variable "target_included_labels" {
   default     = {}
 }
 
+variable "target_included_label_selector" {
+  type        = string
+  description = "Label selector, e.g. `env in (ci,test), !owner`, projects must match to be deleted. See the function README for the syntax."
+  default     = ""
+}
+
+variable "target_excluded_label_selector" {
+  type        = string
+  description = "Label selector, e.g. `keep || env=~prod-.*`, matching projects that won't be deleted. See the function README for the syntax."
+  default     = ""
+}
+
 variable "clean_up_org_level_scc_notifications" {
   type        = bool
   description = "Clean up organization level Security Command Center notifications."
@@ -143,12 +203,244 @@ variable "list_billing_sinks_page_size" {
   default     = 200
 }
 
+variable "list_endpoints_services_page_size" {
+  type        = number
+  description = "The maximum number of Endpoints services to return in each call to the `services.list` method of the Service Management API. The minimum value is 1 and the maximum value is 500."
+  default     = 100
+}
+
+variable "list_firewall_policies_page_size" {
+  type        = number
+  description = "The maximum number of folder firewall policies to return in each call to the `firewallPolicies.list` method of the Compute Engine API. The minimum value is 1 and the maximum value is 500."
+  default     = 500
+}
+
+variable "list_tag_keys_page_size" {
+  type        = number
+  description = "The maximum number of organization Tag Keys to return in each call to the `tagKeys.list` method of the Resource Manager API. The minimum value is 1 and the maximum value is 300."
+  default     = 300
+}
+
+variable "list_tag_values_page_size" {
+  type        = number
+  description = "The maximum number of Tag Values to return in each call to the `tagValues.list` method of the Resource Manager API. The minimum value is 1 and the maximum value is 300."
+  default     = 300
+}
+
+variable "report_gcs_bucket" {
+  type        = string
+  description = "Cloud Storage bucket the JSON report of every run is written to. The function service account needs `roles/storage.objectCreator` on it. Reports are not written to Cloud Storage if empty."
+  default     = ""
+}
+
+variable "report_gcs_prefix" {
+  type        = string
+  description = "Prefix of the report object names written to `report_gcs_bucket`, for example `project-cleanup/`."
+  default     = ""
+}
+
+variable "report_pubsub_topic" {
+  type        = string
+  description = "Pub/Sub topic, in the `projects/PROJECT_ID/topics/TOPIC_ID` format, the JSON report of every run is published to. The function service account needs `roles/pubsub.publisher` on it. Reports are not published if empty."
+  default     = ""
+}
+
+variable "return_error_on_failure" {
+  type        = bool
+  description = "Return the aggregated error of every failed step from the function, so the invocation is reported as failed by Cloud Functions."
+  default     = false
+}
+
 variable "target_folder_id" {
   type        = string
   description = "Folder ID to delete all projects under."
   default     = ""
 }
 
+variable "target_included_folders" {
+  type        = list(string)
+  description = "List of regular expressions. Only folders whose display name matches at least one of them will be deleted."
+  default     = []
+}
+
+variable "target_excluded_folders" {
+  type        = list(string)
+  description = "List of regular expressions. Folders whose display name matches one of them won't be deleted."
+  default     = []
+}
+
+variable "target_excluded_folder_tags" {
+  type        = list(string)
+  description = "List of namespaced tag keys or values, e.g. `123456789/protected`. Folders with one of them among their effective tags won't be deleted."
+  default     = []
+}
+
+variable "folder_deletion_mode" {
+  type        = string
+  description = "Either `always`, to attempt the deletion of every old enough folder matching the filters, or `empty`, to only delete the folders left with no active project or subfolder by the run."
+  default     = "always"
+}
+
+variable "protected_folder_depth" {
+  type        = number
+  description = "Number of folder levels below each target folder which are never deleted. `1` keeps the direct children of the target folder, `0` only the target folder itself."
+  default     = 1
+}
+
+variable "protected_resources" {
+  type        = list(string)
+  description = "Resources which are never deleted, whatever the filters, as `projects/<id or number>`, `folders/<id>`, `tagKeys/<id>` or `billingAccounts/<id>/sinks/<name>`. The project hosting the function and its ancestor folders are always protected."
+  default     = []
+}
+
+variable "target_folders" {
+  type        = any
+  description = "List of additional folders to delete projects under, each an object with a `folder_id` and optional project filters overriding the global ones, e.g. `[{folder_id = \"123\", max_project_age_hours = 168, target_included_labels = {env = \"sandbox\"}}]`."
+  default     = []
+}
+
+variable "clean_up_organization_projects" {
+  type        = bool
+  description = "Also delete the projects directly under the organization which match the filters."
+  default     = false
+}
+
+variable "dry_run" {
+  type        = bool
+  description = "Only log the projects, folders and organization level resources that would be deleted, without deleting anything. Can be overridden per run with `{\"dry_run\": true}` in the Pub/Sub message payload."
+  default     = false
+}
+
+variable "function_event_trigger_failure_policy_retry" {
+  type        = bool
+  default     = false
+  description = "A toggle to determine if the function should be retried on failure. Only meaningful together with `return_error_on_failure`."
+}
+
+variable "project_parallelism" {
+  type        = number
+  description = "The maximum number of projects cleaned up concurrently."
+  default     = 10
+}
+
+variable "max_concurrent_api_calls" {
+  type        = number
+  description = "The maximum number of concurrent calls made to every Google Cloud API."
+  default     = 10
+}
+
+variable "operation_timeout_seconds" {
+  type        = number
+  description = "Time, in seconds, to wait for the long-running operation of every deletion, e.g. of a folder, tag or GKE cluster, before reporting it as still running. `0` doesn't wait."
+  default     = 120
+}
+
+variable "operation_poll_interval_seconds" {
+  type        = number
+  description = "Interval, in seconds, between two polls of a long-running operation."
+  default     = 5
+}
+
+variable "cluster_deletion_timeout_seconds" {
+  type        = number
+  description = "Time budget, in seconds, shared by the deletions of the GKE clusters of a project. The project is deleted in the same run when its clusters are gone within the budget, otherwise it is deferred to a later run. `0` doesn't wait."
+  default     = 120
+}
+
+variable "cluster_stopping_alert_hours" {
+  type        = number
+  description = "Number of hours after which a GKE cluster still in `STOPPING` state is reported as failed, from the start of its deletion operation, even across runs. `0` disables the alert."
+  default     = 24
+}
+
+variable "checkpoint_gcs_bucket" {
+  type        = string
+  description = "Cloud Storage bucket the checkpoint of a run stopped before its deadline is kept in, so that the next run resumes where it stopped. Runs always start from the root folders if empty. The function service account needs `roles/storage.objectUser` on it."
+  default     = ""
+}
+
+variable "checkpoint_gcs_object" {
+  type        = string
+  description = "Name of the checkpoint objects in `checkpoint_gcs_bucket`, each one gets a hash of its targets before the extension."
+  default     = "checkpoint.json"
+}
+
+variable "deadline_margin_seconds" {
+  type        = number
+  description = "Margin, in seconds, before `function_timeout_s` from which a run doesn't start new work and saves its checkpoint."
+  default     = 60
+}
+
+variable "retry_max_attempts" {
+  type        = number
+  description = "The maximum number of attempts of every API call failing with a transient error, including the first one."
+  default     = 5
+}
+
+variable "retry_initial_delay_ms" {
+  type        = number
+  description = "Delay, in milliseconds, before the first retry of a failed API call. It doubles with every retry, up to one minute, with a random jitter."
+  default     = 1000
+}
+
+variable "retry_max_elapsed_seconds" {
+  type        = number
+  description = "Time, in seconds, after which a failing API call is no longer retried."
+  default     = 300
+}
+
+variable "notify_google_chat_webhook_url" {
+  type        = string
+  description = "Google Chat space webhook URL the outcome of every run which deleted, scheduled or failed to delete projects is posted to."
+  default     = ""
+  sensitive   = true
+}
+
+variable "notify_slack_webhook_url" {
+  type        = string
+  description = "Slack incoming webhook URL the outcome of every run which deleted, scheduled or failed to delete projects is posted to."
+  default     = ""
+  sensitive   = true
+}
+
+variable "notify_smtp_address" {
+  type        = string
+  description = "SMTP server, in the `host:port` format, used to email the outcome of every run which deleted, scheduled or failed to delete projects."
+  default     = ""
+}
+
+variable "notify_smtp_from" {
+  type        = string
+  description = "Sender address of the notification emails."
+  default     = ""
+}
+
+variable "notify_smtp_password" {
+  type        = string
+  description = "Password used to authenticate to `notify_smtp_address`."
+  default     = ""
+  sensitive   = true
+}
+
+variable "notify_smtp_to" {
+  type        = list(string)
+  description = "Recipient addresses of the notification emails."
+  default     = []
+}
+
+variable "notify_smtp_username" {
+  type        = string
+  description = "Username used to authenticate to `notify_smtp_address`, no authentication if empty."
+  default     = ""
+}
+
+variable "notify_webhook_url" {
+  type        = string
+  description = "URL the outcome, including the full report, of every run which deleted, scheduled or failed to delete projects is posted to as JSON."
+  default     = ""
+  sensitive   = true
+}
+
 variable "function_docker_registry" {
   type        = string
   default     = null